@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/joho/godotenv"
+	"litedrive/internal/cache/redis"
+	"litedrive/internal/models"
+	"log"
+)
+
+// 重建 Redis 中的文件哈希索引, Redis 数据被清空后执行
+func main() {
+	godotenv.Load()
+
+	models.InitDatabase()
+	defer models.CloseDatabase()
+	redis.InitRedis()
+	defer redis.CloseRedis()
+
+	log.Println("开始重建文件哈希索引...")
+	total, err := models.RebuildFileIndex()
+	if err != nil {
+		log.Fatalf("重建文件哈希索引失败: %v", err)
+	}
+	log.Printf("文件哈希索引重建完成, 共 %d 个文件", total)
+}
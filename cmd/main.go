@@ -1,34 +1,88 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"github.com/joho/godotenv"
+	"litedrive/internal/cache/rabbitmq"
 	"litedrive/internal/cache/redis"
 	"litedrive/internal/firesystem/ceph"
 	"litedrive/internal/firesystem/cos"
+	"litedrive/internal/firesystem/local"
 	"litedrive/internal/models"
+	"litedrive/internal/queue"
 	"litedrive/internal/router"
+	"litedrive/internal/services/explorer"
+	"litedrive/internal/services/transfer"
 	"litedrive/internal/utils"
 	"log"
+	"net/http"
+	"os/signal"
 	"strconv"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// 收到退出信号后等待处理中的请求完成的最长时间
+const shutdownTimeout = 30 * time.Second
+
 func init() {
 	godotenv.Load()
 	models.InitDatabase()
 	redis.InitRedis()
+	local.InitLocalStore()
 	ceph.InitCephClient()
 	cos.InitCosClient()
+	// 开启异步转移时声明转移队列, 连接失败时上传仍可进行, 推送任务时重新连接
+	q := queue.Init()
+	if rabbitmq.AsyncTransferEnabled() && !q.InProcess() && !rabbitmq.InitChannel() {
+		log.Println("RabbitMQ 初始化失败, 转移任务暂时无法投递")
+	}
 }
 
 func main() {
 	defer models.CloseDatabase()
 	defer redis.CloseRedis()
+	defer queue.Close()
 	//加载配置文件
 	config, _ := utils.LoadConfig()
+	//清理过期的分块上传
+	explorer.StartUploadSweeper()
+	//回收没有引用的文件
+	explorer.StartFileReaper()
+	//校验已存储文件的完整性
+	explorer.StartScrubber()
+	//投递发件箱中的后台任务
+	queue.StartOutboxDispatcher()
+	// 收到 SIGINT / SIGTERM 后停止接收请求及新的转移任务, 等待处理中的转移完成后退出
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	//进程内任务队列在当前进程处理转移任务
+	var workers sync.WaitGroup
+	if q := queue.Default(); q.InProcess() {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			transfer.Run(ctx, q)
+		}()
+	}
 	//注册路由
 	api := router.InitRouter()
-	if err := api.Run(":" + strconv.Itoa(config.Server.Port)); err != nil {
-		log.Fatal(err)
-	}
+	srv := &http.Server{Addr: ":" + strconv.Itoa(config.Server.Port), Handler: api}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
 
+	<-ctx.Done()
+	log.Println("正在关闭服务...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("关闭 HTTP 服务失败: %v", err)
+	}
+	workers.Wait()
+	log.Println("服务已退出")
 }
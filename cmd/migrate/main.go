@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/joho/godotenv"
+	"gorm.io/gorm"
+	"litedrive/internal/firesystem"
+	"litedrive/internal/firesystem/blob"
+	"litedrive/internal/firesystem/ceph"
+	"litedrive/internal/firesystem/cos"
+	"litedrive/internal/firesystem/local"
+	"litedrive/internal/models"
+	"litedrive/pkg/common"
+	"log"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 将文件实体从一个存储后端迁移到另一个后端: 复制对象, 校验目标对象的大小及哈希后更新文件记录, 再删除源对象
+// 已迁移的文件不再有源后端副本, 中断后重新执行即可继续; 目标对象已存在且校验通过时不再复制
+
+const migrateBatch = 100
+
+type options struct {
+	from, to   common.StoreType
+	filter     models.FileFilter
+	afterID    uint
+	limit      int
+	rate       float64 // 每秒最多复制的 MB, 0 为不限制
+	dryRun     bool
+	keepSource bool
+}
+
+type report struct {
+	Files    int   // 处理的文件数
+	Bytes    int64 // 复制的字节数
+	Skipped  int   // 目标后端已有副本而跳过的文件数
+	Failed   int
+	Resumed  int // 目标对象已存在, 无需复制的文件数
+	LastID   uint
+	Duration time.Duration
+}
+
+func main() {
+	var (
+		from       = flag.String("from", "", "源存储后端: local / ceph / cos")
+		to         = flag.String("to", "", "目标存储后端: local / ceph / cos")
+		userID     = flag.Uint("user", 0, "只迁移该用户引用的文件")
+		minSize    = flag.Int64("min-size", 0, "只迁移不小于该大小(字节)的文件")
+		maxSize    = flag.Int64("max-size", 0, "只迁移不大于该大小(字节)的文件")
+		olderThan  = flag.Duration("older-than", 0, "只迁移创建时间早于该时长之前的文件, 例如 720h")
+		afterID    = flag.Uint("after-id", 0, "从该文件 ID 之后开始")
+		limit      = flag.Int("limit", 0, "最多迁移的文件数, 0 为不限制")
+		rate       = flag.Float64("rate", 0, "每秒最多复制的 MB, 0 为不限制")
+		dryRun     = flag.Bool("dry-run", false, "只统计待迁移的文件, 不做修改")
+		keepSource = flag.Bool("keep-source", false, "迁移后保留源对象")
+	)
+	flag.Parse()
+
+	opts := options{
+		from:       common.ParseStoreType(*from),
+		to:         common.ParseStoreType(*to),
+		afterID:    *afterID,
+		limit:      *limit,
+		rate:       *rate,
+		dryRun:     *dryRun,
+		keepSource: *keepSource,
+	}
+	if opts.from.String() != strings.ToLower(*from) || opts.to.String() != strings.ToLower(*to) {
+		log.Fatal("-from 和 -to 需要为 local / ceph / cos")
+	}
+	if opts.from == opts.to {
+		log.Fatal("需要指定不同的 -from 和 -to")
+	}
+	opts.filter = models.FileFilter{
+		Backend: opts.from.String(),
+		UserID:  *userID,
+		MinSize: *minSize,
+		MaxSize: *maxSize,
+		// 转移中的文件由转移服务处理
+		Status: models.FileStatusStored,
+	}
+	if *olderThan > 0 {
+		opts.filter.CreatedBefore = time.Now().Add(-*olderThan)
+	}
+
+	godotenv.Load()
+	models.InitDatabase()
+	defer models.CloseDatabase()
+	local.InitLocalStore()
+	ceph.InitCephClient()
+	cos.InitCosClient()
+
+	// 收到退出信号后处理完当前文件再退出
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	r, err := migrate(ctx, opts)
+	if err != nil {
+		log.Printf("迁移中断: %v", err)
+	}
+	mode := "迁移"
+	if opts.dryRun {
+		mode = "待迁移"
+	}
+	log.Printf("%s %s -> %s: 文件 %d 个, %s; 目标已有副本跳过 %d 个, 目标对象已存在 %d 个, 失败 %d 个, 耗时 %s",
+		mode, opts.from, opts.to, r.Files, formatBytes(r.Bytes), r.Skipped, r.Resumed, r.Failed, r.Duration.Round(time.Second))
+	if r.LastID > 0 {
+		log.Printf("最后处理的文件 ID: %d, 可通过 -after-id 从此处继续", r.LastID)
+	}
+}
+
+func migrate(ctx context.Context, opts options) (report, error) {
+	var r report
+	start := time.Now()
+	defer func() { r.Duration = time.Since(start) }()
+
+	src, err := firesystem.GetDriver(opts.from)
+	if err != nil {
+		return r, err
+	}
+	dst, err := firesystem.GetDriver(opts.to)
+	if err != nil {
+		return r, err
+	}
+
+	afterID := opts.afterID
+	for {
+		files, err := models.FindFiles(opts.filter, afterID, migrateBatch)
+		if err != nil {
+			return r, err
+		}
+		for i := range files {
+			if ctx.Err() != nil {
+				return r, ctx.Err()
+			}
+			if opts.limit > 0 && r.Files >= opts.limit {
+				return r, nil
+			}
+			file := &files[i]
+			afterID = file.ID
+			r.LastID = file.ID
+
+			if err := migrateFile(ctx, opts, src, dst, file, &r); err != nil {
+				log.Printf("迁移文件 %d (%s) 失败: %v", file.ID, file.Sha, err)
+				r.Failed++
+			}
+		}
+		if len(files) < migrateBatch {
+			return r, nil
+		}
+	}
+}
+
+func migrateFile(ctx context.Context, opts options, src, dst firesystem.Driver, file *models.File, r *report) error {
+	replicas, err := blob.Replicas(file)
+	if err != nil {
+		return err
+	}
+	var source *models.FileReplica
+	for i := range replicas {
+		switch replicas[i].Backend {
+		case opts.from.String():
+			source = &replicas[i]
+		case opts.to.String():
+			// 目标后端已有副本, 迁移会减少副本数, 跳过
+			r.Skipped++
+			return nil
+		}
+	}
+	if source == nil {
+		return nil
+	}
+
+	if opts.dryRun {
+		log.Printf("待迁移: 文件 %d %s %s", file.ID, file.Sha, formatBytes(file.Size))
+		r.Files++
+		r.Bytes += file.Size
+		return nil
+	}
+
+	dstKey, err := firesystem.ObjectKey(opts.to, file.Sha)
+	if err != nil {
+		return err
+	}
+	// 上次中断时可能已经复制完成
+	if blob.Verify(ctx, dst, dstKey, file.Sha, file.Size) == nil {
+		r.Resumed++
+	} else {
+		if err := copyObject(ctx, src, source.Path, dst, dstKey, file.Size, opts.rate); err != nil {
+			return err
+		}
+		if err := blob.Verify(ctx, dst, dstKey, file.Sha, file.Size); err != nil {
+			dst.Delete(context.Background(), dstKey)
+			return fmt.Errorf("校验目标对象失败: %w", err)
+		}
+		r.Bytes += file.Size
+	}
+
+	if err := models.MoveReplica(file.ID, opts.from.String(), opts.to.String(), dstKey); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// 文件在迁移过程中被回收
+			dst.Delete(context.Background(), dstKey)
+			return nil
+		}
+		return err
+	}
+	r.Files++
+
+	if !opts.keepSource {
+		if err := src.Delete(context.Background(), source.Path); err != nil {
+			log.Printf("删除源对象 %s:%s 失败: %v", opts.from, source.Path, err)
+		}
+	}
+	return nil
+}
+
+// copyObject 将源对象复制到目标后端, rate 为每秒最多复制的 MB
+func copyObject(ctx context.Context, src firesystem.Driver, srcKey string, dst firesystem.Driver, dstKey string, size int64, rate float64) error {
+	reader, err := src.Get(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return dst.Put(ctx, dstKey, firesystem.NewRateLimitedReader(reader, rate*1024*1024), size)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
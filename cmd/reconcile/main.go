@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/joho/godotenv"
+	"litedrive/internal/firesystem"
+	"litedrive/internal/firesystem/ceph"
+	"litedrive/internal/firesystem/cos"
+	"litedrive/internal/firesystem/local"
+	"litedrive/internal/models"
+	"litedrive/internal/utils"
+	"litedrive/pkg/common"
+	"log"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 对账存储后端与数据库
+//   - 孤立对象: 分页列出后端中的对象, 按批查询没有文件实体引用的对象, 例如写入对象后插入记录失败、删除记录后删除对象失败
+//   - 丢失的副本: 分页遍历文件记录, 逐个 Stat 副本的对象 key, 确认不存在时才视为丢失; 文件仍有其他副本时删除该副本记录,
+//     所有副本都丢失时将文件标记为 lost, 引用它的用户文件保留并报告, 由运维人员处理
+//   - 悬空记录: 所引用的文件实体已不存在的用户文件及副本记录
+// 默认只报告, -delete 时才修改; 修改时间或更新时间在 grace 之内的对象和记录可能属于正在进行的上传, 不做处理
+// 分块暂存目录由上传会话清理任务负责, 不在对账范围内
+
+const reconcileBatch = 500
+
+type options struct {
+	backends []common.StoreType
+	grace    time.Duration
+	pageSize int
+	delete   bool
+}
+
+type report struct {
+	Objects          int   // 列出的对象数
+	Orphans          int   // 孤立对象数
+	OrphanBytes      int64 // 孤立对象的字节数
+	OrphansDeleted   int
+	MissingReplicas  int // 丢失的副本数, 文件仍有其他副本
+	ReplicasRemoved  int
+	LostFiles        int // 所有副本都丢失的文件数
+	LostFilesMarked  int
+	LostUserFiles    int64 // 引用副本全部丢失的文件的用户文件数
+	DanglingUsers    int   // 所引用的文件已不存在的用户文件数
+	DanglingReplicas int64
+	Failed           int
+	Duration         time.Duration
+}
+
+func main() {
+	var (
+		backends = flag.String("backend", "", "对账的存储后端, 多个以逗号分隔, 为空时为所有已初始化的后端")
+		grace    = flag.Duration("grace", 48*time.Hour, "只处理修改时间早于该时长之前的对象和记录, 需要大于上传会话有效期")
+		pageSize = flag.Int("page-size", 1000, "每页列出的对象数")
+		del      = flag.Bool("delete", false, "删除孤立对象及悬空记录, 默认只报告")
+	)
+	flag.Parse()
+
+	godotenv.Load()
+	models.InitDatabase()
+	defer models.CloseDatabase()
+	local.InitLocalStore()
+	ceph.InitCephClient()
+	cos.InitCosClient()
+
+	opts := options{grace: *grace, pageSize: *pageSize, delete: *del}
+	if opts.pageSize <= 0 {
+		log.Fatal("-page-size 需要大于 0")
+	}
+	if *backends == "" {
+		opts.backends = firesystem.Registered()
+	} else {
+		for _, name := range strings.Split(*backends, ",") {
+			name = strings.TrimSpace(name)
+			t := common.ParseStoreType(name)
+			if t.String() != strings.ToLower(name) || t == common.StoreMix || t == common.StoreAll {
+				log.Fatalf("无效的存储后端: %s, 需要为 local / ceph / cos", name)
+			}
+			opts.backends = append(opts.backends, t)
+		}
+	}
+	if config, err := utils.LoadConfig(); err == nil && config.Storage.UploadSessionTTL > 0 &&
+		opts.grace < time.Duration(config.Storage.UploadSessionTTL)*time.Second {
+		log.Printf("警告: -grace 小于上传会话有效期, 未完成的直传对象可能被当作孤立对象")
+	}
+
+	// 收到退出信号后处理完当前一页再退出
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	r, err := reconcile(ctx, opts)
+	if err != nil {
+		log.Printf("对账中断: %v", err)
+	}
+	mode := "待删除"
+	if opts.delete {
+		mode = "已删除"
+	}
+	marked := "待标记"
+	if opts.delete {
+		marked = "已标记"
+	}
+	log.Printf("对账完成: 列出对象 %d 个, 孤立对象 %d 个(%s, %s %d 个); 丢失副本 %d 个(%s %d 个); 副本全部丢失的文件 %d 个(%s %d 个, 引用它们的用户文件 %d 个需要人工处理); 悬空用户文件 %d 个, 悬空副本记录 %d 个; 失败 %d 个, 耗时 %s",
+		r.Objects, r.Orphans, formatBytes(r.OrphanBytes), mode, r.OrphansDeleted, r.MissingReplicas, mode, r.ReplicasRemoved,
+		r.LostFiles, marked, r.LostFilesMarked, r.LostUserFiles, r.DanglingUsers, r.DanglingReplicas, r.Failed, r.Duration.Round(time.Second))
+}
+
+func reconcile(ctx context.Context, opts options) (report, error) {
+	var r report
+	start := time.Now()
+	defer func() { r.Duration = time.Since(start) }()
+	cutoff := start.Add(-opts.grace)
+
+	// 参与对账的后端, 只检查这些后端上的副本是否丢失
+	drivers := make(map[string]firesystem.Driver)
+	for _, t := range opts.backends {
+		d, err := firesystem.GetDriver(t)
+		if err != nil {
+			log.Printf("存储后端 %s 不可用, 跳过该后端: %v", t, err)
+			r.Failed++
+			continue
+		}
+		drivers[t.String()] = d
+		if err := reconcileObjects(ctx, t, d, cutoff, opts, &r); err != nil {
+			if ctx.Err() != nil {
+				return r, ctx.Err()
+			}
+			log.Printf("列出 %s 中的对象失败: %v", t, err)
+			r.Failed++
+		}
+	}
+
+	if err := reconcileFiles(ctx, drivers, cutoff, opts, &r); err != nil {
+		return r, err
+	}
+	return r, reconcileDangling(ctx, cutoff, opts, &r)
+}
+
+// reconcileObjects 分页列出后端中的对象, 报告或删除没有文件实体引用的对象
+func reconcileObjects(ctx context.Context, t common.StoreType, d firesystem.Driver, cutoff time.Time, opts options, r *report) error {
+	prefix, skip, err := objectScope(t)
+	if err != nil {
+		return err
+	}
+
+	return firesystem.ListPages(ctx, d, prefix, opts.pageSize, func(objects []firesystem.ObjectInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var candidates []string
+		sizes := make(map[string]int64)
+		for _, o := range objects {
+			if skip != "" && (o.Key == skip || strings.HasPrefix(o.Key, skip+"/")) {
+				continue
+			}
+			r.Objects++
+			if o.ModTime.After(cutoff) {
+				continue
+			}
+			candidates = append(candidates, o.Key)
+			sizes[o.Key] = o.Size
+		}
+
+		referenced, err := models.ReferencedObjectKeys(t.String(), candidates)
+		if err != nil {
+			return err
+		}
+		for _, key := range candidates {
+			if referenced[key] {
+				continue
+			}
+			r.Orphans++
+			r.OrphanBytes += sizes[key]
+			log.Printf("孤立对象 %s:%s (%s)", t, key, formatBytes(sizes[key]))
+			if !opts.delete {
+				continue
+			}
+			ok, err := deleteOrphan(ctx, t, d, key, cutoff)
+			if err != nil {
+				log.Printf("删除孤立对象 %s:%s 失败: %v", t, key, err)
+				r.Failed++
+				continue
+			}
+			if ok {
+				r.OrphansDeleted++
+			}
+		}
+		return nil
+	})
+}
+
+// objectScope 返回后端中属于文件存储的对象前缀, 以及需要跳过的目录(本地存储的分块暂存目录)
+func objectScope(t common.StoreType) (prefix, skip string, err error) {
+	config, err := utils.LoadConfig()
+	if err != nil {
+		return "", "", err
+	}
+	switch t {
+	case common.StoreCeph:
+		return config.Storage.CephRootDir, "", nil
+	case common.StoreCOS:
+		return config.Storage.CosRootDir, "", nil
+	}
+
+	partRoot := config.Storage.TempPartRoot
+	if partRoot == "" {
+		partRoot = filepath.Join(config.Storage.Root, ".parts")
+	}
+	rel, err := filepath.Rel(filepath.Clean(config.Storage.Root), filepath.Clean(partRoot))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", "", nil
+	}
+	return "", path.Clean(filepath.ToSlash(rel)), nil
+}
+
+// deleteOrphan 删除前重新确认对象没有被修改、也没有被新创建的文件引用, 避免与相同内容的上传竞争
+func deleteOrphan(ctx context.Context, t common.StoreType, d firesystem.Driver, key string, cutoff time.Time) (bool, error) {
+	info, err := d.Stat(ctx, key)
+	if errors.Is(err, firesystem.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime.After(cutoff) {
+		return false, nil
+	}
+	referenced, err := models.ReferencedObjectKeys(t.String(), []string{key})
+	if err != nil {
+		return false, err
+	}
+	if referenced[key] {
+		return false, nil
+	}
+	return true, d.Delete(ctx, key)
+}
+
+// reconcileFiles 检查已存储的文件实体的副本是否存在于后端中
+// 最近更新过的文件和副本(包括刚完成转移、迁移或完整性校验的)不做处理
+func reconcileFiles(ctx context.Context, drivers map[string]firesystem.Driver, cutoff time.Time, opts options, r *report) error {
+	afterID := uint(0)
+	for {
+		files, err := models.GetFilesWithReplicas(afterID, reconcileBatch)
+		if err != nil {
+			return err
+		}
+		for i := range files {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			afterID = files[i].ID
+			if files[i].Status == models.FileStatusStored {
+				reconcileFile(ctx, &files[i], drivers, cutoff, opts, r)
+			}
+		}
+		if len(files) < reconcileBatch {
+			return nil
+		}
+	}
+}
+
+// classifyReplicas 逐个 Stat 文件实体的副本, 只有后端确认对象不存在的副本才算丢失
+// 后端未参与对账、最近更新过或 Stat 失败的副本无法确认, unknown 为 true
+func classifyReplicas(ctx context.Context, file *models.File, drivers map[string]firesystem.Driver, cutoff time.Time) (present, missing []models.FileReplica, unknown bool, err error) {
+	for _, rep := range fileReplicas(file) {
+		d, ok := drivers[rep.Backend]
+		if !ok || rep.UpdatedAt.After(cutoff) {
+			unknown = true
+			continue
+		}
+		_, statErr := d.Stat(ctx, rep.Path)
+		switch {
+		case statErr == nil:
+			present = append(present, rep)
+		case errors.Is(statErr, firesystem.ErrNotExist):
+			missing = append(missing, rep)
+		default:
+			unknown = true
+			err = errors.Join(err, fmt.Errorf("获取对象 %s:%s 信息失败: %w", rep.Backend, rep.Path, statErr))
+		}
+	}
+	return present, missing, unknown, err
+}
+
+func reconcileFile(ctx context.Context, file *models.File, drivers map[string]firesystem.Driver, cutoff time.Time, opts options, r *report) {
+	present, missing, unknown, err := classifyReplicas(ctx, file, drivers, cutoff)
+	if err != nil {
+		log.Printf("检查文件 %d 的副本失败: %v", file.ID, err)
+		r.Failed++
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	if len(present) == 0 && !unknown {
+		r.LostFiles++
+		refs, err := models.CountFileReferences(models.DB, file.ID)
+		if err != nil {
+			log.Printf("统计文件 %d 的引用失败: %v", file.ID, err)
+			r.Failed++
+		}
+		r.LostUserFiles += refs
+		log.Printf("文件 %d (%s) 的所有副本都已丢失, 被 %d 个用户文件引用", file.ID, file.Sha, refs)
+		// 用户文件不自动删除, 只标记文件实体, 由运维人员恢复对象或通知用户后处理
+		if !opts.delete {
+			return
+		}
+		marked, err := models.MarkFileLost(file.ID, cutoff)
+		if err != nil {
+			log.Printf("标记文件 %d 丢失失败: %v", file.ID, err)
+			r.Failed++
+			return
+		}
+		if marked {
+			r.LostFilesMarked++
+		}
+		return
+	}
+
+	for _, rep := range missing {
+		r.MissingReplicas++
+		log.Printf("文件 %d 的副本 %s:%s 已丢失", file.ID, rep.Backend, rep.Path)
+		// 无法确认其他副本存在时只报告
+		if !opts.delete || len(present) == 0 {
+			continue
+		}
+		var err error
+		if rep.Backend == file.Backend {
+			// 主副本丢失时以存在的副本作为主副本
+			err = models.MoveReplica(file.ID, rep.Backend, present[0].Backend, present[0].Path)
+		} else {
+			err = models.DeleteReplica(models.DB, file.ID, rep.Backend)
+		}
+		if err != nil {
+			log.Printf("删除文件 %d 的副本记录 %s 失败: %v", file.ID, rep.Backend, err)
+			r.Failed++
+			continue
+		}
+		r.ReplicasRemoved++
+	}
+}
+
+// fileReplicas 文件实体的全部副本, 没有副本记录的主副本以 File 中的记录为准
+func fileReplicas(file *models.File) []models.FileReplica {
+	primary := models.FileReplica{FileID: file.ID, Backend: file.Backend, Path: file.Path}
+	primary.UpdatedAt = file.UpdatedAt
+	result := []models.FileReplica{primary}
+	for _, rep := range file.Replicas {
+		if rep.Backend == file.Backend {
+			result[0] = rep
+			continue
+		}
+		result = append(result, rep)
+	}
+	return result
+}
+
+// reconcileDangling 报告或删除所引用的文件实体已不存在的用户文件及副本记录
+func reconcileDangling(ctx context.Context, cutoff time.Time, opts options, r *report) error {
+	afterID := uint(0)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		userFiles, err := models.FindDanglingUserFiles(cutoff, afterID, reconcileBatch)
+		if err != nil {
+			return err
+		}
+		ids := make([]uint, 0, len(userFiles))
+		for _, u := range userFiles {
+			afterID = u.ID
+			ids = append(ids, u.ID)
+			log.Printf("用户 %d 的文件 %d (%s) 所引用的文件实体 %d 已不存在", u.UserID, u.ID, u.FileName, u.FileID)
+		}
+		r.DanglingUsers += len(userFiles)
+		if opts.delete {
+			if _, err := models.DeleteDanglingUserFiles(ids); err != nil {
+				return err
+			}
+		}
+		if len(userFiles) < reconcileBatch {
+			break
+		}
+	}
+
+	n, err := models.DeleteDanglingReplicas(!opts.delete)
+	r.DanglingReplicas = n
+	return err
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"litedrive/internal/firesystem"
+	"litedrive/internal/firesystem/local"
+	"litedrive/internal/models"
+	"litedrive/internal/models/modelstest"
+	"testing"
+	"time"
+)
+
+// testBackends 两个临时目录中的本地存储, 分别作为 local 及 ceph 后端参与对账
+func testBackends(t *testing.T) map[string]firesystem.Driver {
+	t.Helper()
+	return map[string]firesystem.Driver{
+		"local": &local.Driver{Root: t.TempDir()},
+		"ceph":  &local.Driver{Root: t.TempDir()},
+	}
+}
+
+func putObject(t *testing.T, d firesystem.Driver, key string) {
+	t.Helper()
+	if err := d.Put(context.Background(), key, bytes.NewReader([]byte("x")), 1); err != nil {
+		t.Fatalf("写入对象 %s 失败: %v", key, err)
+	}
+}
+
+// createFile 创建主副本位于 local、另一个副本位于 ceph 的文件实体及引用它的用户文件
+func createFile(t *testing.T, sha string) *models.File {
+	t.Helper()
+	file := modelstest.CreateFile(t, sha, "local", "local/"+sha)
+	for _, r := range []models.FileReplica{
+		{FileID: file.ID, Backend: "local", Path: "local/" + sha, Status: "active"},
+		{FileID: file.ID, Backend: "ceph", Path: "ceph/" + sha, Status: "active"},
+	} {
+		if err := models.SaveReplica(models.DB, &r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := models.DB.Create(&models.UserFile{UserID: 1, FileID: file.ID, FileName: sha}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return loadFile(t, file.ID)
+}
+
+func loadFile(t *testing.T, id uint) *models.File {
+	t.Helper()
+	files, err := models.GetFilesWithReplicas(id-1, 1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("读取文件 %d 失败: %v", id, err)
+	}
+	return &files[0]
+}
+
+// 所有记录都早于 cutoff, 不受 grace 限制
+func testCutoff() time.Time {
+	return time.Now().Add(time.Minute)
+}
+
+func TestClassifyReplicas(t *testing.T) {
+	modelstest.Open(t)
+	drivers := testBackends(t)
+	file := createFile(t, "sha-classify")
+	putObject(t, drivers["local"], file.Path)
+
+	present, missing, unknown, err := classifyReplicas(context.Background(), file, drivers, testCutoff())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(present) != 1 || present[0].Backend != "local" {
+		t.Fatalf("存在的副本为 %+v, 期望 local", present)
+	}
+	if len(missing) != 1 || missing[0].Backend != "ceph" {
+		t.Fatalf("丢失的副本为 %+v, 期望 ceph", missing)
+	}
+	if unknown {
+		t.Fatal("所有副本都已确认, unknown 应为 false")
+	}
+}
+
+// 没有参与对账的后端及最近更新的副本无法确认, 不能算作丢失
+func TestClassifyReplicasUnknown(t *testing.T) {
+	modelstest.Open(t)
+	drivers := testBackends(t)
+	file := createFile(t, "sha-unknown")
+
+	_, missing, unknown, err := classifyReplicas(context.Background(), file, map[string]firesystem.Driver{"local": drivers["local"]}, testCutoff())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unknown || len(missing) != 1 || missing[0].Backend != "local" {
+		t.Fatalf("未参与对账的后端: missing %+v, unknown %v", missing, unknown)
+	}
+
+	_, missing, unknown, err = classifyReplicas(context.Background(), file, drivers, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unknown || len(missing) != 0 {
+		t.Fatalf("最近更新的副本: missing %+v, unknown %v", missing, unknown)
+	}
+}
+
+// 对象 key 不在当前根目录下(例如修改 ceph_root_dir 之前写入的对象)时, 以 Stat 的结果为准
+func TestClassifyReplicasOutsideCurrentRoot(t *testing.T) {
+	modelstest.Open(t)
+	drivers := testBackends(t)
+	file := createFile(t, "sha-legacy")
+	if err := models.DB.Model(&models.FileReplica{}).Where("file_id = ? AND backend = ?", file.ID, "ceph").
+		Update("path", "old-root/sha-legacy").Error; err != nil {
+		t.Fatal(err)
+	}
+	file = loadFile(t, file.ID)
+	putObject(t, drivers["local"], file.Path)
+	putObject(t, drivers["ceph"], "old-root/sha-legacy")
+
+	present, missing, _, err := classifyReplicas(context.Background(), file, drivers, testCutoff())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 0 || len(present) != 2 {
+		t.Fatalf("存在的副本 %+v, 丢失的副本 %+v, 期望两个副本都存在", present, missing)
+	}
+}
+
+// 所有副本都丢失时只标记文件, 引用它的用户文件保留
+func TestReconcileFileMarksLostFile(t *testing.T) {
+	modelstest.Open(t)
+	drivers := testBackends(t)
+	file := createFile(t, "sha-lost")
+
+	var r report
+	reconcileFile(context.Background(), file, drivers, testCutoff(), options{delete: true}, &r)
+	if r.LostFiles != 1 || r.LostFilesMarked != 1 || r.LostUserFiles != 1 {
+		t.Fatalf("丢失文件 %d 个, 标记 %d 个, 用户文件 %d 个, 期望各 1 个", r.LostFiles, r.LostFilesMarked, r.LostUserFiles)
+	}
+	if got := loadFile(t, file.ID); got.Status != models.FileStatusLost || len(got.Replicas) != 2 {
+		t.Fatalf("文件状态为 %q, 副本记录 %d 个, 期望 lost 且保留副本记录", got.Status, len(got.Replicas))
+	}
+	if n, _ := models.CountFileReferences(models.DB, file.ID); n != 1 {
+		t.Fatalf("用户文件剩余 %d 个, 期望保留 1 个", n)
+	}
+}
+
+func TestReconcileFileRemovesMissingReplica(t *testing.T) {
+	cases := []struct {
+		name     string
+		existing string // 存在的副本所在的后端
+		primary  string // 处理后的主副本后端
+	}{
+		{"其他副本丢失", "local", "local"},
+		{"主副本丢失", "ceph", "ceph"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			modelstest.Open(t)
+			drivers := testBackends(t)
+			file := createFile(t, "sha-missing")
+			putObject(t, drivers[tc.existing], tc.existing+"/sha-missing")
+
+			// 默认只报告, 不修改记录
+			var r report
+			reconcileFile(context.Background(), file, drivers, testCutoff(), options{}, &r)
+			if r.MissingReplicas != 1 || r.ReplicasRemoved != 0 {
+				t.Fatalf("只报告时: 丢失 %d 个, 删除 %d 个", r.MissingReplicas, r.ReplicasRemoved)
+			}
+			if got := loadFile(t, file.ID); len(got.Replicas) != 2 {
+				t.Fatal("只报告时删除了副本记录")
+			}
+
+			r = report{}
+			reconcileFile(context.Background(), file, drivers, testCutoff(), options{delete: true}, &r)
+			if r.ReplicasRemoved != 1 {
+				t.Fatalf("删除了 %d 个副本记录, 期望 1 个", r.ReplicasRemoved)
+			}
+			got := loadFile(t, file.ID)
+			if got.Backend != tc.primary || got.Path != tc.existing+"/sha-missing" {
+				t.Fatalf("主副本为 %s:%s, 期望 %s", got.Backend, got.Path, tc.primary)
+			}
+			if len(got.Replicas) != 1 || got.Replicas[0].Backend != tc.existing {
+				t.Fatalf("副本记录为 %+v, 期望只保留 %s", got.Replicas, tc.existing)
+			}
+			if got.Status != models.FileStatusStored {
+				t.Fatalf("文件状态为 %q, 期望 stored", got.Status)
+			}
+		})
+	}
+}
@@ -1,50 +1,20 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"github.com/joho/godotenv"
 	"litedrive/internal/cache/rabbitmq"
+	"litedrive/internal/firesystem/ceph"
 	"litedrive/internal/firesystem/cos"
+	"litedrive/internal/firesystem/local"
 	"litedrive/internal/models"
+	"litedrive/internal/queue"
+	"litedrive/internal/services/transfer"
 	"log"
-	"os"
+	"os/signal"
+	"syscall"
 )
 
-func ProcessTransfer(msg []byte) bool {
-	//解析msg
-	pubData := rabbitmq.TransferData{}
-	err := json.Unmarshal(msg, &pubData)
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-
-	//根据临时存储文件路径，创建文件句柄
-	file, err := os.Open(pubData.CurLocation)
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-	defer file.Close()
-
-	//通过文件句柄将文件内容读出来并且上传到 cos
-	//err = cos.UploadFile(pubData.FileHash, file)
-	err = cos.UploadFile(pubData.DestLocation, file)
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-
-	//更新文件的存储路径到文件表
-	err = models.UpdateFilePathBySha(pubData.FileHash, pubData.DestLocation)
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-
-	return true
-}
-
 func main() {
 	log.Println("开始监听转移消息队列...")
 
@@ -54,15 +24,26 @@ func main() {
 	}
 
 	models.InitDatabase()
+	defer models.CloseDatabase()
+	local.InitLocalStore()
+	ceph.InitCephClient()
 	cos.InitCosClient()
 
-	// ✅ **先手动初始化 RabbitMQ**
-	if !rabbitmq.InitChannel() {
-		log.Fatal("RabbitMQ 初始化失败")
+	tc, err := rabbitmq.LoadTransferConfig()
+	if err != nil {
+		log.Fatal(err)
 	}
+	q := queue.Init()
+	defer q.Close()
+	if q.InProcess() {
+		log.Fatal("任务队列为进程内实现, 转移任务由 API 服务处理, 无需启动转移服务")
+	}
+
+	// 收到 SIGINT / SIGTERM 后停止接收新消息, 等待处理中的转移完成后退出
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	rabbitmq.StartConsume(
-		rabbitmq.TransCOSQueueName,
-		"transfer_cos",
-		ProcessTransfer)
+	log.Printf("转移服务已启动, 并发数 %d, 预取 %d", tc.Concurrency, tc.Prefetch)
+	transfer.Run(ctx, q)
+	log.Println("转移服务已退出")
 }
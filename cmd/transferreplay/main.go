@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"github.com/joho/godotenv"
+	"litedrive/internal/cache/rabbitmq"
+	"litedrive/internal/models"
+	"litedrive/internal/queue"
+	"litedrive/internal/services/transfer"
+	"litedrive/internal/utils"
+	"log"
+)
+
+// 重新投递重试耗尽的转移任务, 排除故障后执行
+//   - queue: 将 RabbitMQ 错误队列中的任务重新投递到转移队列
+//   - db: 为数据库中转移失败的文件重新写入转移任务, 由 API 服务的发件箱投递, 适用于任意任务队列
+func main() {
+	limit := flag.Int("limit", 0, "最多重新投递的任务数, 0 表示全部")
+	source := flag.String("source", "", "重新投递的来源: queue / db, 为空时 rabbitmq 使用 queue, 其他任务队列使用 db")
+	flag.Parse()
+
+	godotenv.Load()
+
+	models.InitDatabase()
+	defer models.CloseDatabase()
+
+	if *source == "" {
+		*source = "db"
+		if config, err := utils.LoadConfig(); err == nil && (config.Queue.Backend == "" || config.Queue.Backend == queue.BackendRabbitMQ) {
+			*source = "queue"
+		}
+	}
+
+	switch *source {
+	case "db":
+		requeued, err := transfer.RequeueFailed(*limit)
+		if err != nil {
+			log.Fatalf("重新写入转移任务失败, 已写入 %d 个: %v", requeued, err)
+		}
+		log.Printf("重新写入转移任务 %d 个, 由 API 服务投递", requeued)
+	case "queue":
+		if !rabbitmq.InitChannel() {
+			log.Fatal("RabbitMQ 初始化失败")
+		}
+		defer rabbitmq.Close()
+
+		replayed, err := rabbitmq.ReplayDeadLetters(rabbitmq.TransferTopic, *limit, transfer.MarkReplayed)
+		if err != nil {
+			log.Fatalf("重新投递转移任务失败, 已投递 %d 个: %v", replayed, err)
+		}
+		log.Printf("重新投递转移任务 %d 个", replayed)
+	default:
+		log.Fatalf("无效的来源: %s, 需要为 queue / db", *source)
+	}
+}
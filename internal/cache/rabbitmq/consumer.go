@@ -1,39 +1,165 @@
 package rabbitmq
 
-import "log"
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"log"
+	"sync"
+	"time"
+)
 
-var done = make(chan bool)
+// 消息处理失败时不再丢弃: 按重试次数投递到对应的延迟队列, 超过最大重试次数后投递到错误队列
+// 消息处理完成(成功、进入重试或错误队列)后才确认, 进程中途退出时消息由 RabbitMQ 重新投递
 
-// 开始监听队列,获取消息
-func StartConsume(qName, cName string, callback func(msg []byte) bool) {
+// 开始监听任务队列,获取消息, ctx 取消或连接断开后返回
+// 按配置的并发数同时处理消息, ctx 取消后停止接收新消息, 等待处理中的消息完成, 已预取未处理的消息退回队列
+// onDeadLetter 在消息重试耗尽转入错误队列后调用, 可以为 nil
+func StartConsume(ctx context.Context, topic, cName string, callback func(msg []byte) error, onDeadLetter func(msg []byte, cause error)) error {
+	ch, tc, t, err := topicChannel(topic)
+	if err != nil {
+		return err
+	}
+	// 最多预取 Prefetch 条消息, 确认前不再投递
+	if err := ch.Qos(tc.Prefetch, 0, false); err != nil {
+		return err
+	}
 	//通过 channel.consume 获得消息信道
-	msgs, err := rabbitChannel.Consume(
-		qName,
+	msgs, err := ch.Consume(
+		t.Queue,
 		cName,
-		true,
+		false,
 		false,
 		false,
 		false,
 		nil)
 	if err != nil {
-		log.Fatal(err)
-		return
+		return err
 	}
 
+	// 收到退出信号后取消订阅, 服务端确认后 msgs 关闭
+	stopped := make(chan struct{})
 	go func() {
-		//循环获取队列中的新消息
-		for msg := range msgs {
-			//调用callback方法来处理新的消息
-			procssSuc := callback(msg.Body)
-			if !procssSuc {
-				//TODO 将任务写到另一个队列中，用于一场情况的重试
+		select {
+		case <-ctx.Done():
+			if err := ch.Cancel(cName, false); err != nil {
+				// 无法取消订阅时关闭 channel, 未确认的消息由服务端退回队列
+				log.Printf("取消订阅失败: %v", err)
+				ch.Close()
 			}
+		case <-stopped:
 		}
 	}()
 
-	// done 没有信息 就会进入阻塞状态
-	<-done
+	var wg sync.WaitGroup
+	for i := 0; i < tc.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			//循环获取队列中的新消息
+			for msg := range msgs {
+				if ctx.Err() != nil {
+					msg.Nack(false, true)
+					continue
+				}
+				//调用callback方法来处理新的消息
+				handleDelivery(tc, t, msg, callback, onDeadLetter)
+			}
+		}()
+	}
+	wg.Wait()
+	close(stopped)
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	return errors.New("RabbitMQ 连接已断开")
+}
 
-	//如果收到信息,则会执行
-	rabbitChannel.Close()
+// handleDelivery 处理一条消息, 成功后确认, 失败时投递到重试队列或错误队列后确认
+func handleDelivery(tc *TransferConfig, t Topic, msg amqp.Delivery, callback func(msg []byte) error, onDeadLetter func(msg []byte, cause error)) {
+	procErr := callback(msg.Body)
+	if procErr == nil {
+		msg.Ack(false)
+		return
+	}
+	dead, err := retryOrDeadLetter(tc, t, msg, procErr)
+	if err != nil {
+		log.Printf("投递重试消息失败, 稍后重新处理: %v", err)
+		time.Sleep(time.Second)
+		msg.Nack(false, true)
+		return
+	}
+	msg.Ack(false)
+	if dead && onDeadLetter != nil {
+		onDeadLetter(msg.Body, procErr)
+	}
+}
+
+// retryOrDeadLetter 将处理失败的消息投递到下一级重试队列, 重试次数耗尽时投递到错误队列, dead 为 true
+func retryOrDeadLetter(tc *TransferConfig, t Topic, msg amqp.Delivery, cause error) (dead bool, err error) {
+	attempt := retryCount(msg.Headers) + 1
+	headers := amqp.Table{
+		headerRetryCount: int32(attempt),
+		headerLastError:  cause.Error(),
+	}
+	if attempt > tc.MaxRetries {
+		log.Printf("任务重试 %d 次后仍然失败, 转入错误队列 %s", tc.MaxRetries, t.ErrQueue)
+		return true, publish("", t.ErrQueue, amqp.Publishing{Headers: headers, Body: msg.Body})
+	}
+	delay := tc.retryDelay(attempt)
+	log.Printf("任务处理失败, %s 后第 %d 次重试: %v", delay, attempt, cause)
+	return false, publish("", t.retryQueue(delay), amqp.Publishing{Headers: headers, Body: msg.Body})
+}
+
+// retryCount 读取消息已重试的次数
+func retryCount(headers amqp.Table) int {
+	switch v := headers[headerRetryCount].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// ReplayDeadLetters 将错误队列中的消息重新投递到任务队列并清零重试次数, limit 为 0 时处理当前全部消息
+// onReplay 在每条消息重新投递后调用, 可以为 nil
+func ReplayDeadLetters(topic string, limit int, onReplay func(msg []byte)) (int, error) {
+	ch, tc, t, err := topicChannel(topic)
+	if err != nil {
+		return 0, err
+	}
+
+	// 只处理开始时已在队列中的消息, 避免重放后再次失败的消息被反复处理
+	q, err := ch.QueueDeclarePassive(t.ErrQueue, true, false, false, false, nil)
+	if err != nil {
+		return 0, err
+	}
+	if limit <= 0 || limit > q.Messages {
+		limit = q.Messages
+	}
+
+	replayed := 0
+	for replayed < limit {
+		msg, ok, err := ch.Get(t.ErrQueue, false)
+		if err != nil {
+			return replayed, err
+		}
+		if !ok {
+			break
+		}
+		if err := publish(tc.Exchange, t.RoutingKey, amqp.Publishing{Body: msg.Body}); err != nil {
+			msg.Nack(false, true)
+			return replayed, err
+		}
+		msg.Ack(false)
+		replayed++
+		if onReplay != nil {
+			onReplay(msg.Body)
+		}
+	}
+	return replayed, nil
 }
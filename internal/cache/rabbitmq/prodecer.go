@@ -5,54 +5,32 @@ import (
 	"log"
 )
 
-var rabbitConn *amqp.Connection
-var rabbitChannel *amqp.Channel
-
-func InitChannel() bool {
-	// 判断 channel 是否已经创建
-	if rabbitConn != nil {
-		return true
-	}
-
-	var err error // 提前声明 err 变量
-
-	// 获得 RabbitMQ 的连接
-	rabbitConn, err = amqp.Dial(RabbitURL) // 这里使用 "="
-	if err != nil {
-		log.Fatalf("Failed to connect to RabbitMQ: %s", err)
-		return false
-	}
+// 发布消息
+func Publish(exchange, routingKey string, msg []byte) bool {
+	return publish(exchange, routingKey, amqp.Publishing{Body: msg}) == nil
+}
 
-	// 打开一个 channel 用于消息的发布与接收
-	rabbitChannel, err = rabbitConn.Channel() // 这里也使用 "="
+// PublishTopic 发布指定类型的任务
+func PublishTopic(topic string, data []byte) error {
+	_, tc, t, err := topicChannel(topic)
 	if err != nil {
-		log.Fatalf("Failed to open a channel: %s", err)
-		return false
+		return err
 	}
-
-	return true
+	return publish(tc.Exchange, t.RoutingKey, amqp.Publishing{Body: data})
 }
 
-// 发布消息
-func Publish(exchange, routingKey string, msg []byte) bool {
+func publish(exchange, routingKey string, msg amqp.Publishing) error {
 	// 判断channel是否正常
-	if !InitChannel() {
-		return false
+	ch, err := channel()
+	if err != nil {
+		return err
 	}
+	msg.ContentType = "application/json"
+	msg.DeliveryMode = amqp.Persistent
 	//执行消息发布动作
-	err := rabbitChannel.Publish(
-		exchange,
-		routingKey,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        msg,
-		},
-	)
-	if err != nil {
-		log.Fatalf("Failed to publish a message: %s", err)
-		return false
+	if err := ch.Publish(exchange, routingKey, false, false, msg); err != nil {
+		log.Printf("Failed to publish a message: %s", err)
+		return err
 	}
-	return true
+	return nil
 }
@@ -0,0 +1,239 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"litedrive/internal/firesystem"
+	"litedrive/internal/models"
+	"litedrive/internal/utils"
+	"litedrive/pkg/common"
+	"log"
+	"sync"
+)
+
+// 管理文件实体(models.File)在各存储后端上的副本: 放置、并行写入、按副本回退读取及删除
+
+// Location 一份可读取的副本
+type Location struct {
+	Backend string
+	Key     string
+	Driver  firesystem.Driver
+	Info    *firesystem.ObjectInfo
+}
+
+// Placement 根据当前存储模式决定文件实体写入哪些后端, 第一个为主副本
+func Placement(size int64, userID uint) ([]common.StoreType, error) {
+	config, err := utils.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	storeType := common.ParseStoreType(config.Storage.CurrentStoreType)
+	switch storeType {
+	case common.StoreAll:
+		if len(config.Storage.ReplicaBackends) == 0 {
+			return firesystem.Registered(), nil
+		}
+		return parseBackends(config.Storage.ReplicaBackends)
+	case common.StoreMix:
+		for _, rule := range config.Storage.MixRules {
+			if matchRule(rule, size, userID) {
+				return parseBackends(rule.Backends)
+			}
+		}
+		if len(config.Storage.MixDefault) == 0 {
+			return []common.StoreType{common.StoreLocal}, nil
+		}
+		return parseBackends(config.Storage.MixDefault)
+	default:
+		return []common.StoreType{storeType}, nil
+	}
+}
+
+func matchRule(rule utils.MixRule, size int64, userID uint) bool {
+	if rule.MinSize > 0 && size < rule.MinSize {
+		return false
+	}
+	if rule.MaxSize > 0 && size > rule.MaxSize {
+		return false
+	}
+	if len(rule.UserIDs) > 0 {
+		for _, id := range rule.UserIDs {
+			if id == userID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func parseBackends(names []string) ([]common.StoreType, error) {
+	seen := make(map[common.StoreType]bool)
+	var types []common.StoreType
+	for _, name := range names {
+		t := common.ParseStoreType(name)
+		if t == common.StoreMix || t == common.StoreAll {
+			return nil, fmt.Errorf("无效的副本存储后端: %s", name)
+		}
+		if !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+	if len(types) == 0 {
+		return nil, errors.New("未配置副本存储后端")
+	}
+	return types, nil
+}
+
+// Put 将同一份数据流并行写入多个存储后端, 只读取一次源数据
+// 任意一个后端写入失败时清理已写入的副本并返回错误
+func Put(ctx context.Context, storeTypes []common.StoreType, fileSha string, r io.Reader, size int64) ([]models.FileReplica, error) {
+	replicas := make([]models.FileReplica, len(storeTypes))
+	drivers := make([]firesystem.Driver, len(storeTypes))
+	for i, t := range storeTypes {
+		d, err := firesystem.GetDriver(t)
+		if err != nil {
+			return nil, err
+		}
+		key, err := firesystem.ObjectKey(t, fileSha)
+		if err != nil {
+			return nil, err
+		}
+		drivers[i] = d
+		replicas[i] = models.FileReplica{Backend: t.String(), Path: key, Status: "active"}
+	}
+
+	keys := make([]string, len(replicas))
+	for i, r := range replicas {
+		keys[i] = r.Path
+	}
+	if err := putAll(ctx, drivers, keys, r, size); err != nil {
+		return nil, err
+	}
+	return replicas, nil
+}
+
+// putAll 将数据流并行写入各个驱动的指定 key, 任意一个失败时删除已写入的对象
+func putAll(ctx context.Context, drivers []firesystem.Driver, keys []string, r io.Reader, size int64) error {
+	if len(drivers) == 0 {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}
+	if len(drivers) == 1 {
+		return drivers[0].Put(ctx, keys[0], r, size)
+	}
+
+	writers := make([]io.Writer, len(drivers))
+	pipes := make([]*io.PipeWriter, len(drivers))
+	errs := make([]error, len(drivers))
+	var wg sync.WaitGroup
+	for i := range drivers {
+		pr, pw := io.Pipe()
+		writers[i], pipes[i] = pw, pw
+		wg.Add(1)
+		go func(i int, pr *io.PipeReader) {
+			defer wg.Done()
+			errs[i] = drivers[i].Put(ctx, keys[i], pr, size)
+			// 让写端感知到该后端已结束, 避免阻塞其他后端
+			pr.CloseWithError(errs[i])
+		}(i, pr)
+	}
+
+	_, copyErr := io.Copy(io.MultiWriter(writers...), r)
+	for _, pw := range pipes {
+		pw.CloseWithError(copyErr)
+	}
+	wg.Wait()
+
+	err := copyErr
+	for _, e := range errs {
+		if e != nil {
+			err = e
+			break
+		}
+	}
+	if err != nil {
+		for i, e := range errs {
+			if e == nil {
+				if delErr := drivers[i].Delete(context.Background(), keys[i]); delErr != nil {
+					log.Printf("清理对象 %s 失败: %v", keys[i], delErr)
+				}
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// Replicas 返回文件实体的全部副本, 主副本在前
+// 历史文件没有副本记录时, 以 File 中的主副本为准
+func Replicas(file *models.File) ([]models.FileReplica, error) {
+	primary := models.FileReplica{FileID: file.ID, Backend: file.Backend, Path: file.Path, Status: "active"}
+	replicas, err := models.GetReplicasByFileID(file.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []models.FileReplica{primary}
+	for _, r := range replicas {
+		if r.Backend == file.Backend {
+			result[0] = r
+			continue
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// Locate 按主副本优先的顺序返回第一个可用的副本, 某个后端不可用时回退到其他副本
+func Locate(ctx context.Context, file *models.File) (*Location, error) {
+	replicas, err := Replicas(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// 所有副本都不存在时返回 ErrNotExist, 否则返回后端不可用的错误
+	var lastErr error = firesystem.ErrNotExist
+	for _, r := range replicas {
+		if r.Status != "active" {
+			continue
+		}
+		d, err := firesystem.DriverFor(r.Backend)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		info, err := d.Stat(ctx, r.Path)
+		if err != nil {
+			log.Printf("副本 %s:%s 不可用: %v", r.Backend, r.Path, err)
+			if !errors.Is(err, firesystem.ErrNotExist) {
+				lastErr = err
+			}
+			continue
+		}
+		return &Location{Backend: r.Backend, Key: r.Path, Driver: d, Info: info}, nil
+	}
+	return nil, lastErr
+}
+
+// Remove 删除文件实体在所有后端上的副本
+func Remove(ctx context.Context, file *models.File) error {
+	replicas, err := Replicas(file)
+	if err != nil {
+		return err
+	}
+	for _, r := range replicas {
+		d, err := firesystem.DriverFor(r.Backend)
+		if err != nil {
+			return err
+		}
+		if err := d.Delete(ctx, r.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package blob
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"litedrive/internal/firesystem"
+	"litedrive/internal/models"
+	"litedrive/internal/utils"
+	"litedrive/pkg/common"
+	"log"
+	"os"
+	"sync"
+)
+
+// 流式写入: 文件内容只读取一次, 同时计算 SHA-256、统计大小并写入各个后端
+// 支持 Mover 的后端先写入临时 key, 得到哈希后在后端内移动到以哈希为 key 的位置;
+// 不支持的后端先暂存到本地临时文件, 得到哈希后再从暂存文件写入
+
+// Staged 已写入临时位置、尚未确定最终 key 的文件
+type Staged struct {
+	Sha  string
+	Size int64
+
+	targets []stagedTarget
+	// 本地暂存文件, 只有存在不支持 Mover 的后端时才会创建
+	stagingPath string
+}
+
+type stagedTarget struct {
+	storeType common.StoreType
+	driver    firesystem.Driver
+	tempKey   string // 为空表示该后端使用本地暂存文件
+}
+
+// countingWriter 统计写入的字节数
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// Stage 读取一次数据流, 写入各个后端的临时位置并计算哈希, size 与实际大小不一致时返回错误
+func Stage(ctx context.Context, storeTypes []common.StoreType, r io.Reader, size int64) (*Staged, error) {
+	tempID, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	staged := &Staged{targets: make([]stagedTarget, len(storeTypes))}
+	var drivers []firesystem.Driver
+	var keys []string
+	needStaging := false
+	for i, t := range storeTypes {
+		d, err := firesystem.GetDriver(t)
+		if err != nil {
+			return nil, err
+		}
+		staged.targets[i] = stagedTarget{storeType: t, driver: d}
+		if _, ok := d.(firesystem.Mover); !ok {
+			needStaging = true
+			continue
+		}
+		key, err := firesystem.TempKey(t, tempID)
+		if err != nil {
+			return nil, err
+		}
+		staged.targets[i].tempKey = key
+		drivers = append(drivers, d)
+		keys = append(keys, key)
+	}
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	writers := []io.Writer{hash, counter}
+	if needStaging {
+		f, err := os.CreateTemp(stagingDir(), ".upload-*")
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		staged.stagingPath = f.Name()
+		writers = append(writers, f)
+	}
+
+	if err := putAll(ctx, drivers, keys, io.TeeReader(r, io.MultiWriter(writers...)), size); err != nil {
+		// putAll 失败时已删除写入成功的临时对象
+		staged.removeStaging()
+		return nil, err
+	}
+	if size >= 0 && counter.n != size {
+		staged.Discard(context.Background())
+		return nil, fmt.Errorf("文件大小不匹配: 期望 %d, 实际 %d", size, counter.n)
+	}
+
+	staged.Sha = hex.EncodeToString(hash.Sum(nil))
+	staged.Size = counter.n
+	return staged, nil
+}
+
+// Commit 将临时位置的内容移动到以哈希为 key 的位置, 返回各个副本
+// 失败时只清理临时位置, 已经写入最终 key 的对象可能属于并发上传的相同文件, 不做删除
+func (s *Staged) Commit(ctx context.Context) ([]models.FileReplica, error) {
+	defer s.removeStaging()
+
+	replicas := make([]models.FileReplica, len(s.targets))
+	for i, t := range s.targets {
+		key, err := firesystem.ObjectKey(t.storeType, s.Sha)
+		if err != nil {
+			s.Discard(context.Background())
+			return nil, err
+		}
+		if err := t.commit(ctx, key, s.stagingPath, s.Size); err != nil {
+			s.Discard(context.Background())
+			return nil, err
+		}
+		s.targets[i].tempKey = ""
+		replicas[i] = models.FileReplica{Backend: t.storeType.String(), Path: key, Status: "active"}
+	}
+	return replicas, nil
+}
+
+func (t stagedTarget) commit(ctx context.Context, key, stagingPath string, size int64) error {
+	if t.tempKey == "" {
+		f, err := os.Open(stagingPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return t.driver.Put(ctx, key, f, size)
+	}
+
+	err := t.driver.(firesystem.Mover).Move(ctx, t.tempKey, key)
+	if !errors.Is(err, firesystem.ErrNotSupported) {
+		return err
+	}
+	// 后端无法移动该对象时(本地存储跨文件系统), 读取临时对象重新写入
+	// Ceph / COS 超过 5GB 的对象由 Move 分块复制, 不会走到这里
+	rc, err := t.driver.Get(ctx, t.tempKey)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := t.driver.Put(ctx, key, rc, size); err != nil {
+		return err
+	}
+	return t.driver.Delete(ctx, t.tempKey)
+}
+
+// Discard 删除临时位置的内容, 例如相同内容的文件已经存在时
+func (s *Staged) Discard(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, t := range s.targets {
+		if t.tempKey == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(t stagedTarget) {
+			defer wg.Done()
+			if err := t.driver.Delete(ctx, t.tempKey); err != nil {
+				log.Printf("删除临时对象 %s:%s 失败: %v", t.storeType, t.tempKey, err)
+			}
+		}(t)
+	}
+	wg.Wait()
+	s.removeStaging()
+}
+
+func (s *Staged) removeStaging() {
+	if s.stagingPath == "" {
+		return
+	}
+	if err := os.Remove(s.stagingPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("删除暂存文件失败: %v", err)
+	}
+	s.stagingPath = ""
+}
+
+// stagingDir 本地暂存目录, 未配置 TempLocalRoot 时使用系统临时目录
+func stagingDir() string {
+	config, err := utils.LoadConfig()
+	if err != nil || config.Storage.TempLocalRoot == "" {
+		return os.TempDir()
+	}
+	if err := os.MkdirAll(config.Storage.TempLocalRoot, os.ModePerm); err != nil {
+		return os.TempDir()
+	}
+	return config.Storage.TempLocalRoot
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,45 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"litedrive/internal/firesystem"
+)
+
+// ErrContentMismatch 对象的大小或哈希与文件实体不一致
+var ErrContentMismatch = errors.New("文件内容与声明不一致")
+
+// Verify 读取存储后端中的对象, 校验大小及 SHA-256, 对象不存在时返回 firesystem.ErrNotExist
+func Verify(ctx context.Context, driver firesystem.Driver, key, fileSha string, size int64) error {
+	return VerifyRate(ctx, driver, key, fileSha, size, 0)
+}
+
+// VerifyRate 与 Verify 相同, bytesPerSec 限制每秒读取的字节数, 不大于 0 时不限速
+func VerifyRate(ctx context.Context, driver firesystem.Driver, key, fileSha string, size int64, bytesPerSec float64) error {
+	info, err := driver.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	if info.Size != size {
+		return fmt.Errorf("%w: 文件大小期望 %d, 实际 %d", ErrContentMismatch, size, info.Size)
+	}
+
+	reader, err := driver.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, firesystem.NewRateLimitedReader(reader, bytesPerSec)); err != nil {
+		return err
+	}
+	if sha := hex.EncodeToString(hash.Sum(nil)); sha != fileSha {
+		return fmt.Errorf("%w: 文件哈希期望 %s, 实际 %s", ErrContentMismatch, fileSha, sha)
+	}
+	return nil
+}
@@ -11,7 +11,9 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"golang.org/x/net/context"
 	"io"
+	"litedrive/internal/firesystem"
 	"litedrive/internal/utils"
+	"litedrive/pkg/common"
 	"log"
 )
 
@@ -65,6 +67,7 @@ func InitCephClient() {
 		log.Printf("桶 %s 已存在", bucketName)
 	}
 
+	firesystem.Register(common.StoreCeph, &Driver{Bucket: bucketName})
 	log.Println("Ceph 客户端初始化成功并通过连接测试")
 }
 
@@ -105,24 +108,25 @@ func DeleteBucket(bucketName string) error {
 	return nil
 }
 
-// ListObjects 列出存储桶中的对象
-func ListObjects(bucketName string) error {
-	resp, err := CephClient.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucketName),
-	})
+// ListObjects 分页列出存储桶中 prefix 下 marker 之后的对象, 每页最多 maxKeys 个
+// next 为下一页的 marker, 为空表示已经列出全部对象
+func ListObjects(ctx context.Context, bucketName, prefix, marker string, maxKeys int32) (objects []types.Object, next string, err error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucketName),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(maxKeys),
+	}
+	if marker != "" {
+		input.StartAfter = aws.String(marker)
+	}
+	resp, err := CephClient.ListObjectsV2(ctx, input)
 	if err != nil {
-		return fmt.Errorf("列出对象失败: %w", err)
+		return nil, "", fmt.Errorf("列出对象失败: %w", err)
 	}
-
-	if len(resp.Contents) == 0 {
-		fmt.Println("存储桶为空")
-	} else {
-		fmt.Println("存储桶中的对象:")
-		for _, object := range resp.Contents {
-			fmt.Printf("- %s\n", *object.Key)
-		}
+	if aws.ToBool(resp.IsTruncated) && len(resp.Contents) > 0 {
+		next = aws.ToString(resp.Contents[len(resp.Contents)-1].Key)
 	}
-	return nil
+	return resp.Contents, next, nil
 }
 
 // UploadObject 上传对象
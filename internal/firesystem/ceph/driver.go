@@ -0,0 +1,310 @@
+package ceph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"io"
+	"litedrive/internal/firesystem"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Driver Ceph 存储驱动, 通过 S3 协议访问指定桶
+type Driver struct {
+	Bucket string
+}
+
+var (
+	_ firesystem.Driver          = (*Driver)(nil)
+	_ firesystem.UploadPresigner = (*Driver)(nil)
+	_ firesystem.MultipartDriver = (*Driver)(nil)
+	_ firesystem.Mover           = (*Driver)(nil)
+)
+
+const (
+	// maxCopySize 单次 CopyObject 支持的最大对象, 更大的对象使用分块复制
+	maxCopySize = 5 * 1024 * 1024 * 1024
+	// copyPartSize 分块复制时每个分块的大小
+	copyPartSize = 1024 * 1024 * 1024
+)
+
+func (d *Driver) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(d.Bucket),
+		Key:    aws.String(key),
+		Body:   r,
+	}
+	if size >= 0 {
+		input.ContentLength = aws.Int64(size)
+	}
+	// 上传流不一定可 seek, 不对 payload 做签名
+	_, err := CephClient.PutObject(ctx, input, s3.WithAPIOptions(v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware))
+	if err != nil {
+		return fmt.Errorf("上传对象失败: %w", err)
+	}
+	return nil
+}
+
+func (d *Driver) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := CephClient.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(d.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, wrapError(err, "下载对象失败")
+	}
+	return resp.Body, nil
+}
+
+func (d *Driver) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	resp, err := CephClient.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(d.Bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(firesystem.RangeHeader(offset, length)),
+	})
+	if err != nil {
+		return nil, wrapError(err, "下载对象失败")
+	}
+	return resp.Body, nil
+}
+
+func (d *Driver) Stat(ctx context.Context, key string) (*firesystem.ObjectInfo, error) {
+	resp, err := CephClient.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(d.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, wrapError(err, "获取对象信息失败")
+	}
+	return &firesystem.ObjectInfo{
+		Key:     key,
+		Size:    aws.ToInt64(resp.ContentLength),
+		ModTime: aws.ToTime(resp.LastModified),
+		ETag:    aws.ToString(resp.ETag),
+	}, nil
+}
+
+func (d *Driver) Delete(ctx context.Context, key string) error {
+	_, err := CephClient.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(d.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("删除对象失败: %w", err)
+	}
+	return nil
+}
+
+func (d *Driver) List(ctx context.Context, prefix string) ([]firesystem.ObjectInfo, error) {
+	var objects []firesystem.ObjectInfo
+	paginator := s3.NewListObjectsV2Paginator(CephClient, &s3.ListObjectsV2Input{
+		Bucket: aws.String(d.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("列出对象失败: %w", err)
+		}
+		for _, object := range page.Contents {
+			objects = append(objects, firesystem.ObjectInfo{
+				Key:     aws.ToString(object.Key),
+				Size:    aws.ToInt64(object.Size),
+				ModTime: aws.ToTime(object.LastModified),
+				ETag:    aws.ToString(object.ETag),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (d *Driver) ListPage(ctx context.Context, prefix, marker string, limit int) ([]firesystem.ObjectInfo, string, error) {
+	page, next, err := ListObjects(ctx, d.Bucket, prefix, marker, int32(limit))
+	if err != nil {
+		return nil, "", err
+	}
+	objects := make([]firesystem.ObjectInfo, 0, len(page))
+	for _, object := range page {
+		objects = append(objects, firesystem.ObjectInfo{
+			Key:     aws.ToString(object.Key),
+			Size:    aws.ToInt64(object.Size),
+			ModTime: aws.ToTime(object.LastModified),
+			ETag:    aws.ToString(object.ETag),
+		})
+	}
+	return objects, next, nil
+}
+
+func (d *Driver) PresignGet(ctx context.Context, key string, ttl time.Duration, fileName string) (string, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(d.Bucket),
+		Key:    aws.String(key),
+	}
+	if fileName != "" {
+		input.ResponseContentDisposition = aws.String(firesystem.ContentDisposition(fileName))
+	}
+	req, err := s3.NewPresignClient(CephClient).PresignGetObject(ctx, input, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("生成下载链接失败: %w", err)
+	}
+	return req.URL, nil
+}
+
+// wrapError 将 S3 的 404 错误统一转换为 firesystem.ErrNotExist
+func wrapError(err error, msg string) error {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	var apiErr smithy.APIError
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) ||
+		(errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotFound") {
+		return firesystem.ErrNotExist
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+func (d *Driver) PresignPut(ctx context.Context, key string, size int64, ttl time.Duration) (string, error) {
+	req, err := s3.NewPresignClient(CephClient).PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(d.Bucket),
+		Key:           aws.String(key),
+		ContentLength: aws.Int64(size),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("生成上传链接失败: %w", err)
+	}
+	return req.URL, nil
+}
+
+func (d *Driver) CreateMultipart(ctx context.Context, key string) (string, error) {
+	resp, err := CephClient.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(d.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("初始化分块上传失败: %w", err)
+	}
+	return aws.ToString(resp.UploadId), nil
+}
+
+func (d *Driver) UploadPart(ctx context.Context, key, uploadID string, partNumber int, r io.Reader, size int64) (string, error) {
+	resp, err := CephClient.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(d.Bucket),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(int32(partNumber)),
+		Body:          r,
+		ContentLength: aws.Int64(size),
+	}, s3.WithAPIOptions(v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware))
+	if err != nil {
+		return "", fmt.Errorf("上传分块失败: %w", err)
+	}
+	return aws.ToString(resp.ETag), nil
+}
+
+func (d *Driver) PresignPart(ctx context.Context, key, uploadID string, partNumber int, ttl time.Duration) (string, error) {
+	req, err := s3.NewPresignClient(CephClient).PresignUploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(d.Bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int32(int32(partNumber)),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("生成分块上传链接失败: %w", err)
+	}
+	return req.URL, nil
+}
+
+func (d *Driver) CompleteMultipart(ctx context.Context, key, uploadID string, parts []firesystem.Part) error {
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, p := range parts {
+		completed = append(completed, types.CompletedPart{
+			ETag:       aws.String(p.ETag),
+			PartNumber: aws.Int32(int32(p.Number)),
+		})
+	}
+	_, err := CephClient.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(d.Bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("合并分块失败: %w", err)
+	}
+	return nil
+}
+
+func (d *Driver) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	_, err := CephClient.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(d.Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		return fmt.Errorf("取消分块上传失败: %w", err)
+	}
+	return nil
+}
+
+// Move S3 协议没有重命名, 通过服务端复制后删除源对象实现, 超过 5GB 的对象使用分块复制
+func (d *Driver) Move(ctx context.Context, srcKey, dstKey string) error {
+	info, err := d.Stat(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+
+	segments := strings.Split(srcKey, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	copySource := d.Bucket + "/" + strings.Join(segments, "/")
+	if info.Size > maxCopySize {
+		err = d.copyMultipart(ctx, copySource, dstKey, info.Size)
+	} else {
+		_, err = CephClient.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(d.Bucket),
+			Key:        aws.String(dstKey),
+			CopySource: aws.String(copySource),
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("复制对象失败: %w", err)
+	}
+	return d.Delete(ctx, srcKey)
+}
+
+// copyMultipart 通过 UploadPartCopy 在服务端分块复制对象, 失败时取消分块上传
+func (d *Driver) copyMultipart(ctx context.Context, copySource, dstKey string, size int64) error {
+	uploadID, err := d.CreateMultipart(ctx, dstKey)
+	if err != nil {
+		return err
+	}
+	var parts []firesystem.Part
+	for offset, number := int64(0), 1; offset < size; offset, number = offset+copyPartSize, number+1 {
+		end := min(offset+copyPartSize, size) - 1
+		resp, err := CephClient.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(d.Bucket),
+			Key:             aws.String(dstKey),
+			UploadId:        aws.String(uploadID),
+			PartNumber:      aws.Int32(int32(number)),
+			CopySource:      aws.String(copySource),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, end)),
+		})
+		if err != nil {
+			d.AbortMultipart(context.Background(), dstKey, uploadID)
+			return err
+		}
+		parts = append(parts, firesystem.Part{Number: number, ETag: aws.ToString(resp.CopyPartResult.ETag)})
+	}
+	if err := d.CompleteMultipart(ctx, dstKey, uploadID, parts); err != nil {
+		d.AbortMultipart(context.Background(), dstKey, uploadID)
+		return err
+	}
+	return nil
+}
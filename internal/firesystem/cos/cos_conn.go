@@ -5,7 +5,9 @@ import (
 	"errors"
 	"github.com/tencentyun/cos-go-sdk-v5"
 	"io"
+	"litedrive/internal/firesystem"
 	"litedrive/internal/utils"
+	"litedrive/pkg/common"
 	"log"
 	"net/http"
 	"net/url"
@@ -44,6 +46,7 @@ func InitCosClient() {
 
 	// 给全局变量赋值,方便service全局调用
 	CosClient = c
+	firesystem.Register(common.StoreCOS, &Driver{})
 	log.Println("腾讯云 COS 连接测试成功")
 }
 
@@ -90,18 +93,26 @@ func DeleteFile(objectKey string) error {
 	return nil
 }
 
-// 查询对象列表
-func ListObjects(prefix string) ([]cos.Object, error) {
+// ListObjects 分页查询 prefix 下 marker 之后的对象, 每页最多 maxKeys 个
+// next 为下一页的 marker, 为空表示已经列出全部对象
+func ListObjects(ctx context.Context, prefix, marker string, maxKeys int) (objects []cos.Object, next string, err error) {
 	opt := &cos.BucketGetOptions{
 		Prefix:  prefix,
-		MaxKeys: 100,
+		Marker:  marker,
+		MaxKeys: maxKeys,
 	}
-	res, _, err := CosClient.Bucket.Get(context.Background(), opt)
+	res, _, err := CosClient.Bucket.Get(ctx, opt)
 	if err != nil {
 		log.Printf("查询对象列表失败: %v", err)
-		return nil, err
+		return nil, "", err
+	}
+	if res.IsTruncated && len(res.Contents) > 0 {
+		next = res.NextMarker
+		if next == "" {
+			next = res.Contents[len(res.Contents)-1].Key
+		}
 	}
-	return res.Contents, nil
+	return res.Contents, next, nil
 }
 
 // 下载对象
@@ -0,0 +1,254 @@
+package cos
+
+import (
+	"context"
+	"fmt"
+	"github.com/tencentyun/cos-go-sdk-v5"
+	"io"
+	"litedrive/internal/firesystem"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Driver 腾讯云 COS 存储驱动, 桶由 Cos.Endpoint 指定
+type Driver struct{}
+
+var (
+	_ firesystem.Driver          = (*Driver)(nil)
+	_ firesystem.UploadPresigner = (*Driver)(nil)
+	_ firesystem.MultipartDriver = (*Driver)(nil)
+	_ firesystem.Mover           = (*Driver)(nil)
+)
+
+const (
+	// maxCopySize 单次复制对象支持的最大对象, 更大的对象使用分块复制
+	maxCopySize = 5 * 1024 * 1024 * 1024
+	// copyPartSize 分块复制时每个分块的大小
+	copyPartSize = 1024 * 1024 * 1024
+)
+
+func (d *Driver) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	var opt *cos.ObjectPutOptions
+	if size >= 0 {
+		opt = &cos.ObjectPutOptions{
+			ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{ContentLength: size},
+		}
+	}
+	if _, err := CosClient.Object.Put(ctx, key, r, opt); err != nil {
+		return fmt.Errorf("上传对象失败: %w", err)
+	}
+	return nil
+}
+
+func (d *Driver) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := CosClient.Object.Get(ctx, key, nil)
+	if err != nil {
+		return nil, wrapError(err, "下载对象失败")
+	}
+	return resp.Body, nil
+}
+
+func (d *Driver) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	resp, err := CosClient.Object.Get(ctx, key, &cos.ObjectGetOptions{
+		Range: firesystem.RangeHeader(offset, length),
+	})
+	if err != nil {
+		return nil, wrapError(err, "下载对象失败")
+	}
+	return resp.Body, nil
+}
+
+func (d *Driver) Stat(ctx context.Context, key string) (*firesystem.ObjectInfo, error) {
+	resp, err := CosClient.Object.Head(ctx, key, nil)
+	if err != nil {
+		return nil, wrapError(err, "获取对象信息失败")
+	}
+	size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &firesystem.ObjectInfo{
+		Key:     key,
+		Size:    size,
+		ModTime: modTime,
+		ETag:    resp.Header.Get("ETag"),
+	}, nil
+}
+
+func (d *Driver) Delete(ctx context.Context, key string) error {
+	if _, err := CosClient.Object.Delete(ctx, key); err != nil {
+		return fmt.Errorf("删除对象失败: %w", err)
+	}
+	return nil
+}
+
+func (d *Driver) List(ctx context.Context, prefix string) ([]firesystem.ObjectInfo, error) {
+	var objects []firesystem.ObjectInfo
+	opt := &cos.BucketGetOptions{
+		Prefix:  prefix,
+		MaxKeys: 1000,
+	}
+	for {
+		res, _, err := CosClient.Bucket.Get(ctx, opt)
+		if err != nil {
+			return nil, fmt.Errorf("列出对象失败: %w", err)
+		}
+		for _, object := range res.Contents {
+			modTime, _ := time.Parse(time.RFC3339, object.LastModified)
+			objects = append(objects, firesystem.ObjectInfo{
+				Key:     object.Key,
+				Size:    object.Size,
+				ModTime: modTime,
+				ETag:    object.ETag,
+			})
+		}
+		if !res.IsTruncated {
+			break
+		}
+		opt.Marker = res.NextMarker
+	}
+	return objects, nil
+}
+
+func (d *Driver) ListPage(ctx context.Context, prefix, marker string, limit int) ([]firesystem.ObjectInfo, string, error) {
+	page, next, err := ListObjects(ctx, prefix, marker, limit)
+	if err != nil {
+		return nil, "", fmt.Errorf("列出对象失败: %w", err)
+	}
+	objects := make([]firesystem.ObjectInfo, 0, len(page))
+	for _, object := range page {
+		modTime, _ := time.Parse(time.RFC3339, object.LastModified)
+		objects = append(objects, firesystem.ObjectInfo{
+			Key:     object.Key,
+			Size:    object.Size,
+			ModTime: modTime,
+			ETag:    object.ETag,
+		})
+	}
+	return objects, next, nil
+}
+
+func (d *Driver) PresignGet(ctx context.Context, key string, ttl time.Duration, fileName string) (string, error) {
+	var opt *cos.ObjectGetOptions
+	if fileName != "" {
+		opt = &cos.ObjectGetOptions{ResponseContentDisposition: firesystem.ContentDisposition(fileName)}
+	}
+	u, err := CosClient.Object.GetPresignedURL(ctx, http.MethodGet, key,
+		CosClient.GetCredential().SecretID, CosClient.GetCredential().SecretKey, ttl, opt)
+	if err != nil {
+		return "", fmt.Errorf("生成下载链接失败: %w", err)
+	}
+	return u.String(), nil
+}
+
+// wrapError 将 COS 的 404 错误统一转换为 firesystem.ErrNotExist
+func wrapError(err error, msg string) error {
+	if cos.IsNotFoundError(err) {
+		return firesystem.ErrNotExist
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+func (d *Driver) PresignPut(ctx context.Context, key string, size int64, ttl time.Duration) (string, error) {
+	header := http.Header{}
+	header.Set("Content-Length", strconv.FormatInt(size, 10))
+	u, err := CosClient.Object.GetPresignedURL(ctx, http.MethodPut, key,
+		CosClient.GetCredential().SecretID, CosClient.GetCredential().SecretKey, ttl,
+		&cos.PresignedURLOptions{Header: &header})
+	if err != nil {
+		return "", fmt.Errorf("生成上传链接失败: %w", err)
+	}
+	return u.String(), nil
+}
+
+func (d *Driver) CreateMultipart(ctx context.Context, key string) (string, error) {
+	res, _, err := CosClient.Object.InitiateMultipartUpload(ctx, key, nil)
+	if err != nil {
+		return "", fmt.Errorf("初始化分块上传失败: %w", err)
+	}
+	return res.UploadID, nil
+}
+
+func (d *Driver) UploadPart(ctx context.Context, key, uploadID string, partNumber int, r io.Reader, size int64) (string, error) {
+	resp, err := CosClient.Object.UploadPart(ctx, key, uploadID, partNumber, r,
+		&cos.ObjectUploadPartOptions{ContentLength: size})
+	if err != nil {
+		return "", fmt.Errorf("上传分块失败: %w", err)
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+func (d *Driver) PresignPart(ctx context.Context, key, uploadID string, partNumber int, ttl time.Duration) (string, error) {
+	query := url.Values{}
+	query.Set("partNumber", strconv.Itoa(partNumber))
+	query.Set("uploadId", uploadID)
+	u, err := CosClient.Object.GetPresignedURL(ctx, http.MethodPut, key,
+		CosClient.GetCredential().SecretID, CosClient.GetCredential().SecretKey, ttl,
+		&cos.PresignedURLOptions{Query: &query})
+	if err != nil {
+		return "", fmt.Errorf("生成分块上传链接失败: %w", err)
+	}
+	return u.String(), nil
+}
+
+func (d *Driver) CompleteMultipart(ctx context.Context, key, uploadID string, parts []firesystem.Part) error {
+	opt := &cos.CompleteMultipartUploadOptions{}
+	for _, p := range parts {
+		opt.Parts = append(opt.Parts, cos.Object{PartNumber: p.Number, ETag: p.ETag})
+	}
+	if _, _, err := CosClient.Object.CompleteMultipartUpload(ctx, key, uploadID, opt); err != nil {
+		return fmt.Errorf("合并分块失败: %w", err)
+	}
+	return nil
+}
+
+func (d *Driver) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	if _, err := CosClient.Object.AbortMultipartUpload(ctx, key, uploadID); err != nil {
+		return fmt.Errorf("取消分块上传失败: %w", err)
+	}
+	return nil
+}
+
+// Move COS 没有重命名, 通过服务端复制后删除源对象实现, 超过 5GB 的对象使用分块复制
+func (d *Driver) Move(ctx context.Context, srcKey, dstKey string) error {
+	info, err := d.Stat(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+
+	sourceURL := CosClient.BaseURL.BucketURL.Host + "/" + srcKey
+	if info.Size > maxCopySize {
+		err = d.copyMultipart(ctx, sourceURL, dstKey, info.Size)
+	} else {
+		_, _, err = CosClient.Object.Copy(ctx, dstKey, sourceURL, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("复制对象失败: %w", err)
+	}
+	return d.Delete(ctx, srcKey)
+}
+
+// copyMultipart 在服务端分块复制对象, 失败时取消分块上传
+func (d *Driver) copyMultipart(ctx context.Context, sourceURL, dstKey string, size int64) error {
+	uploadID, err := d.CreateMultipart(ctx, dstKey)
+	if err != nil {
+		return err
+	}
+	var parts []firesystem.Part
+	for offset, number := int64(0), 1; offset < size; offset, number = offset+copyPartSize, number+1 {
+		end := min(offset+copyPartSize, size) - 1
+		res, _, err := CosClient.Object.CopyPart(ctx, dstKey, uploadID, number, sourceURL, &cos.ObjectCopyPartOptions{
+			XCosCopySourceRange: fmt.Sprintf("bytes=%d-%d", offset, end),
+		})
+		if err != nil {
+			d.AbortMultipart(context.Background(), dstKey, uploadID)
+			return err
+		}
+		parts = append(parts, firesystem.Part{Number: number, ETag: res.ETag})
+	}
+	if err := d.CompleteMultipart(ctx, dstKey, uploadID, parts); err != nil {
+		d.AbortMultipart(context.Background(), dstKey, uploadID)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package firesystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"litedrive/internal/utils"
+	"litedrive/pkg/common"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 统一的存储后端接口, 本地 / Ceph / COS 各自实现并在初始化时注册
+
+var (
+	// ErrNotExist 对象在存储后端中不存在
+	ErrNotExist = errors.New("对象不存在")
+	// ErrNotSupported 存储后端不支持该操作
+	ErrNotSupported = errors.New("存储后端不支持该操作")
+)
+
+// ObjectInfo 存储对象的元信息
+type ObjectInfo struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	ETag    string    `json:"etag,omitempty"`
+}
+
+// Driver 存储驱动接口, key 为对象在该后端中的唯一标识
+type Driver interface {
+	// Put 写入对象, size 未知时传 -1
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get 读取整个对象, 调用方负责关闭返回的 ReadCloser
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange 从 offset 开始读取 length 字节, length 为 -1 时读到对象末尾
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Stat 获取对象元信息, 对象不存在时返回 ErrNotExist
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// Delete 删除对象, 对象不存在时不报错
+	Delete(ctx context.Context, key string) error
+	// List 列出指定前缀下的所有对象
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// PresignGet 生成限时的下载链接, fileName 用于下载时的 Content-Disposition
+	PresignGet(ctx context.Context, key string, ttl time.Duration, fileName string) (string, error)
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[common.StoreType]Driver)
+)
+
+// Register 注册存储驱动, 同一类型重复注册时覆盖
+func Register(storeType common.StoreType, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	drivers[storeType] = driver
+}
+
+// GetDriver 获取指定存储类型的驱动
+func GetDriver(storeType common.StoreType) (Driver, error) {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	d, ok := drivers[storeType]
+	if !ok {
+		return nil, fmt.Errorf("存储后端 %s 未初始化", storeType)
+	}
+	return d, nil
+}
+
+// DriverFor 根据 File.Backend 中记录的名称获取驱动
+func DriverFor(backend string) (Driver, error) {
+	return GetDriver(common.ParseStoreType(backend))
+}
+
+// TempKeyPrefix 上传过程中临时对象的名称前缀, 内容哈希确定后移动到 ObjectKey
+const TempKeyPrefix = ".upload-"
+
+// ObjectKey 生成文件实体在指定后端中的对象 key
+// 本地存储按哈希前两级分目录, 避免单个目录下文件过多
+func ObjectKey(storeType common.StoreType, fileSha string) (string, error) {
+	config, err := utils.LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	switch storeType {
+	case common.StoreCeph:
+		return config.Storage.CephRootDir + fileSha, nil
+	case common.StoreCOS:
+		return config.Storage.CosRootDir + fileSha, nil
+	default:
+		if len(fileSha) < 4 {
+			return fileSha, nil
+		}
+		return fileSha[:2] + "/" + fileSha[2:4] + "/" + fileSha, nil
+	}
+}
+
+// TempKey 生成上传过程中的临时对象 key, 本地存储的临时对象位于 TempLocalRoot 下
+func TempKey(storeType common.StoreType, id string) (string, error) {
+	config, err := utils.LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	switch storeType {
+	case common.StoreCeph:
+		return config.Storage.CephRootDir + TempKeyPrefix + id, nil
+	case common.StoreCOS:
+		return config.Storage.CosRootDir + TempKeyPrefix + id, nil
+	default:
+		return TempKeyPrefix + id, nil
+	}
+}
+
+// Registered 返回所有已注册驱动的存储类型, 按类型排序
+func Registered() []common.StoreType {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	types := make([]common.StoreType, 0, len(drivers))
+	for t := range drivers {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
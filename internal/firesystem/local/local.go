@@ -0,0 +1,218 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"litedrive/internal/firesystem"
+	"litedrive/internal/utils"
+	"litedrive/pkg/common"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 节点本地磁盘存储, 对象 key 为相对于 Storage.Root 的路径
+// 上传过程中的临时对象(TempKeyPrefix 开头的 key)位于 TempRoot 下, 完成后重命名到 Root
+
+// Driver 本地存储驱动
+type Driver struct {
+	Root     string
+	TempRoot string
+}
+
+var (
+	_ firesystem.Driver = (*Driver)(nil)
+	_ firesystem.Mover  = (*Driver)(nil)
+)
+
+// InitLocalStore 初始化本地存储目录并注册驱动
+func InitLocalStore() {
+	config, err := utils.LoadConfig()
+	if err != nil {
+		log.Fatalf("加载配置失败: %v", err)
+	}
+	if err := os.MkdirAll(config.Storage.Root, os.ModePerm); err != nil {
+		log.Fatalf("创建本地存储目录失败: %v", err)
+	}
+	// 临时目录需要与 Root 位于同一文件系统, 否则只能复制
+	tempRoot := config.Storage.TempLocalRoot
+	if tempRoot == "" {
+		tempRoot = filepath.Join(config.Storage.Root, ".tmp")
+	}
+	if err := os.MkdirAll(tempRoot, os.ModePerm); err != nil {
+		log.Fatalf("创建本地临时目录失败: %v", err)
+	}
+	firesystem.Register(common.StoreLocal, &Driver{Root: config.Storage.Root, TempRoot: tempRoot})
+	log.Println("本地存储初始化成功:", config.Storage.Root)
+}
+
+// FullPath 将对象 key 转换为磁盘路径, 拒绝越出 Root 的 key
+func (d *Driver) FullPath(key string) (string, error) {
+	root := filepath.Clean(d.Root)
+	if d.TempRoot != "" && strings.HasPrefix(key, firesystem.TempKeyPrefix) {
+		root = filepath.Clean(d.TempRoot)
+	}
+	p := filepath.Join(root, filepath.FromSlash(key))
+	if p != root && !strings.HasPrefix(p, root+string(os.PathSeparator)) {
+		return "", fmt.Errorf("非法的对象 key: %s", key)
+	}
+	return p, nil
+}
+
+func (d *Driver) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	p, err := d.FullPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+
+	// 先写临时文件再重命名, 避免读到写了一半的对象
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".put-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (d *Driver) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := d.FullPath(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, firesystem.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (d *Driver) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := d.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	f := rc.(*os.File)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (d *Driver) Stat(ctx context.Context, key string) (*firesystem.ObjectInfo, error) {
+	p, err := d.FullPath(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, firesystem.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &firesystem.ObjectInfo{Key: key, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (d *Driver) Delete(ctx context.Context, key string) error {
+	p, err := d.FullPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (d *Driver) List(ctx context.Context, prefix string) ([]firesystem.ObjectInfo, error) {
+	var objects []firesystem.ObjectInfo
+	root := filepath.Clean(d.Root)
+	tempRoot := filepath.Clean(d.TempRoot)
+	err := filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			// 临时目录位于 Root 下时不列出其中的临时对象
+			if d.TempRoot != "" && p == tempRoot {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, firesystem.ObjectInfo{Key: key, Size: fi.Size(), ModTime: fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// PresignGet 本地存储没有对象服务, 由 API 自身签发下载链接
+func (d *Driver) PresignGet(ctx context.Context, key string, ttl time.Duration, fileName string) (string, error) {
+	return "", firesystem.ErrNotSupported
+}
+
+// Move 同一文件系统内重命名, 不复制内容; 跨文件系统时返回 ErrNotSupported 由调用方复制
+func (d *Driver) Move(ctx context.Context, srcKey, dstKey string) error {
+	src, err := d.FullPath(srcKey)
+	if err != nil {
+		return err
+	}
+	dst, err := d.FullPath(dstKey)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return firesystem.ErrNotExist
+		}
+		if errors.Is(err, syscall.EXDEV) {
+			return firesystem.ErrNotSupported
+		}
+		return err
+	}
+	return nil
+}
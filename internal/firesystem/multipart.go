@@ -0,0 +1,83 @@
+package firesystem
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// 对象存储后端的可选能力, 通过类型断言判断驱动是否支持
+
+// Part 分块上传中已上传的一个分块
+type Part struct {
+	Number int    `json:"partNumber"`
+	ETag   string `json:"etag"`
+}
+
+// UploadPresigner 支持由客户端直接上传到桶的后端
+type UploadPresigner interface {
+	// PresignPut 生成限时的上传链接, size 会被签入 Content-Length
+	PresignPut(ctx context.Context, key string, size int64, ttl time.Duration) (string, error)
+}
+
+// MultipartDriver 支持原生分块上传的后端
+type MultipartDriver interface {
+	// CreateMultipart 发起分块上传, 返回后端的 uploadID
+	CreateMultipart(ctx context.Context, key string) (string, error)
+	// UploadPart 由服务端上传一个分块, 返回分块的 ETag
+	UploadPart(ctx context.Context, key, uploadID string, partNumber int, r io.Reader, size int64) (string, error)
+	// PresignPart 生成上传指定分块的限时链接, partNumber 从 1 开始
+	PresignPart(ctx context.Context, key, uploadID string, partNumber int, ttl time.Duration) (string, error)
+	// CompleteMultipart 按分块号顺序合并分块
+	CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error
+	// AbortMultipart 取消分块上传并释放已上传的分块
+	AbortMultipart(ctx context.Context, key, uploadID string) error
+}
+
+// Mover 支持在同一后端内移动对象, 流式上传先写入临时 key, 得到内容哈希后再移动到最终 key
+type Mover interface {
+	// Move 将 srcKey 移动到 dstKey, 后端无法移动该对象时返回 ErrNotSupported
+	Move(ctx context.Context, srcKey, dstKey string) error
+}
+
+// PageLister 支持分页列出对象, 对象较多时不需要一次加载全部对象
+type PageLister interface {
+	// ListPage 列出 prefix 下 marker 之后的最多 limit 个对象, next 为空表示已经列出全部对象
+	ListPage(ctx context.Context, prefix, marker string, limit int) (objects []ObjectInfo, next string, err error)
+}
+
+// ListPages 按页列出 prefix 下的对象并依次交给 fn 处理, 后端不支持分页时一次列出后分批处理
+func ListPages(ctx context.Context, d Driver, prefix string, pageSize int, fn func([]ObjectInfo) error) error {
+	if pl, ok := d.(PageLister); ok {
+		marker := ""
+		for {
+			objects, next, err := pl.ListPage(ctx, prefix, marker, pageSize)
+			if err != nil {
+				return err
+			}
+			if len(objects) > 0 {
+				if err := fn(objects); err != nil {
+					return err
+				}
+			}
+			if next == "" {
+				return nil
+			}
+			marker = next
+		}
+	}
+
+	objects, err := d.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(objects); start += pageSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(objects[start:min(start+pageSize, len(objects))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
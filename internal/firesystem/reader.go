@@ -0,0 +1,129 @@
+package firesystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"time"
+)
+
+// RangeHeader 生成 HTTP Range 请求头, length 为 -1 时表示读到末尾
+func RangeHeader(offset, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
+// ReadSeeker 基于 GetRange 的可 seek 读取器, 供 http.ServeContent 处理 Range 请求
+// Seek 只记录位置, 下一次 Read 时才向存储后端发起从该位置开始的请求
+type ReadSeeker struct {
+	ctx    context.Context
+	driver Driver
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+// NewReadSeeker 创建对象的可 seek 读取器, size 为对象大小
+func NewReadSeeker(ctx context.Context, driver Driver, key string, size int64) *ReadSeeker {
+	return &ReadSeeker{ctx: ctx, driver: driver, key: key, size: size}
+}
+
+func (r *ReadSeeker) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.driver.GetRange(r.ctx, r.key, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *ReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, errors.New("无效的 whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("seek 位置不能为负数")
+	}
+	if abs != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = abs
+	return abs, nil
+}
+
+func (r *ReadSeeker) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// ContentType 根据文件扩展名推断 Content-Type
+func ContentType(fileName string) string {
+	if t := mime.TypeByExtension(filepath.Ext(fileName)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// ContentDisposition 生成附件下载头, 非 ASCII 文件名按 RFC 2231 编码
+func ContentDisposition(fileName string) string {
+	if v := mime.FormatMediaType("attachment", map[string]string{"filename": fileName}); v != "" {
+		return v
+	}
+	return "attachment"
+}
+
+// RateLimitedReader 限制平均读取速度的读取器
+type RateLimitedReader struct {
+	r           io.Reader
+	bytesPerSec float64
+	start       time.Time
+	read        int64
+}
+
+// NewRateLimitedReader 创建限速读取器, bytesPerSec 为每秒最多读取的字节数, 不大于 0 时不限速
+func NewRateLimitedReader(r io.Reader, bytesPerSec float64) io.Reader {
+	if bytesPerSec <= 0 {
+		return r
+	}
+	return &RateLimitedReader{r: r, bytesPerSec: bytesPerSec, start: time.Now()}
+}
+
+func (l *RateLimitedReader) Read(p []byte) (int, error) {
+	// 单次读取不超过 100ms 的配额, 使限速更平滑
+	if max := int(l.bytesPerSec / 10); max > 0 && len(p) > max {
+		p = p[:max]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	expected := time.Duration(float64(l.read) / l.bytesPerSec * float64(time.Second))
+	if wait := expected - time.Since(l.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
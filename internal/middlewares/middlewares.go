@@ -1,8 +1,10 @@
 package middlewares
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"litedrive/internal/utils"
+	"litedrive/pkg/serializer"
 	"net/http"
 )
 
@@ -29,3 +31,21 @@ func JwtAuthMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// AdminMiddleware 只允许配置中的管理员访问, 需要在 JwtAuthMiddleware 之后使用
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetUint("user_id")
+		config, err := utils.LoadConfig()
+		if err == nil {
+			for _, id := range config.Server.AdminUserIDs {
+				if id == userID {
+					c.Next()
+					return
+				}
+			}
+		}
+		c.JSON(http.StatusForbidden, serializer.ForbiddenResponse(errors.New("需要管理员权限")))
+		c.Abort()
+	}
+}
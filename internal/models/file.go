@@ -3,21 +3,145 @@ package models
 import (
 	"errors"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 // File 文件元信息结构
 type File struct {
 	gorm.Model
-	Sha  string `json:"sha" gorm:"type:char(64);unique;not null"`
-	Size int64  `json:"size" gorm:"not null;check:size >= 0"`
-	Path string `json:"path" gorm:"type:varchar(255);not null"`
+	Sha     string `json:"sha" gorm:"type:char(64);unique;not null"`
+	Size    int64  `json:"size" gorm:"not null;check:size >= 0"`
+	Path    string `json:"path" gorm:"type:varchar(255);not null"`                   // 文件在存储后端中的对象 key
+	Backend string `json:"backend" gorm:"type:varchar(20);not null;default:'local'"` // 存储后端: local / ceph / cos
+	// 引用该文件的 UserFile 数, 只在事务中增减, 为 0 的文件由后台任务回收
+	RefCount int64 `json:"refCount" gorm:"not null;default:0;index"`
+	// 生命周期状态, 异步转移完成前主副本为本地副本
+	Status string `json:"status" gorm:"type:varchar(20);not null;default:'stored';index"`
+	// 最近一次完整性校验的时间及结果, 由后台校验任务更新
+	VerifiedAt   *time.Time `json:"verifiedAt" gorm:"index"`
+	VerifyStatus string     `json:"verifyStatus" gorm:"type:varchar(20);index"`
+
+	// 文件实体的所有副本, Backend/Path 为其中的主副本
+	Replicas []FileReplica `json:"replicas,omitempty" gorm:"foreignKey:FileID"`
 }
 
+// 文件实体的生命周期状态
+const (
+	FileStatusStaged       = "staged"       // 已写入本地, 等待转移到目标后端
+	FileStatusTransferring = "transferring" // 转移服务正在写入目标后端
+	FileStatusStored       = "stored"       // 已写入目标后端
+	FileStatusFailed       = "failed"       // 转移重试耗尽, 本地副本仍然可用
+	FileStatusLost         = "lost"         // 对账发现所有副本都已丢失, 等待运维人员处理
+)
+
 // CreateFile 创建文件记录
 func (f *File) CreateFile() error {
 	return DB.Create(f).Error
 }
 
+// CreateFileWithUserFile 在同一事务中创建文件记录、关联用户文件并写入待投递的任务
+// 相同 SHA 的文件已存在时只关联已有文件, 不写入任务, created 为 false
+func CreateFileWithUserFile(f *File, u *UserFile, outbox ...OutboxMessage) (file *File, created bool, err error) {
+	existing, err := GetFileBySha(f.Sha)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing == nil {
+		err = DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(f).Error; err != nil {
+				return err
+			}
+			for i := range outbox {
+				if err := tx.Create(&outbox[i]).Error; err != nil {
+					return err
+				}
+			}
+			u.FileID = f.ID
+			return u.attach(tx)
+		})
+		if err == nil {
+			indexFile(f.Sha, f.ID)
+			return f, true, nil
+		}
+		// 并发上传相同内容时, 唯一索引冲突后以先写入的记录为准, 此时索引可能还未更新
+		if existing, _ = getFileByShaDB(f.Sha); existing == nil {
+			return nil, false, err
+		}
+	}
+
+	u.FileID = existing.ID
+	if err := u.AttachUserFile(); err != nil {
+		return nil, false, err
+	}
+	return existing, false, nil
+}
+
+// CountFileReferences 统计引用文件实体的用户文件数
+func CountFileReferences(tx *gorm.DB, fileID uint) (int64, error) {
+	var count int64
+	err := tx.Model(&UserFile{}).Where("file_id = ?", fileID).Count(&count).Error
+	return count, err
+}
+
+// GetUnreferencedFiles 按 ID 分页获取引用数为 0 且在 before 之前更新的文件, 交给回收任务处理
+func GetUnreferencedFiles(before time.Time, afterID uint, limit int) ([]File, error) {
+	var files []File
+	err := DB.Where("ref_count = 0 AND updated_at < ? AND id > ?", before, afterID).Order("id").Limit(limit).Find(&files).Error
+	return files, err
+}
+
+// ReapFile 在事务中重新确认文件没有引用后删除文件记录及副本记录, 返回被删除的文件及其副本记录, 没有删除时返回 nil
+// 物理副本由调用方在事务提交后删除, 避免在持有行锁期间访问存储后端; 删除失败的对象由对账任务清理
+func ReapFile(fileID uint) (reaped *File, err error) {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var file File
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&file, fileID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		if file.RefCount > 0 {
+			return nil
+		}
+		// 以实际的 UserFile 记录为准, 修正不一致的引用数
+		count, err := CountFileReferences(tx, file.ID)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return tx.Model(&File{}).Where("id = ?", file.ID).Update("ref_count", count).Error
+		}
+
+		if err := tx.Where("file_id = ?", file.ID).Order("id").Find(&file.Replicas).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("file_id = ?", file.ID).Delete(&FileReplica{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&File{}, file.ID).Error; err != nil {
+			return err
+		}
+		reaped = &file
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reaped != nil {
+		unindexFile(reaped.Sha)
+	}
+	return reaped, nil
+}
+
+// BackfillFileRefCount 新增 ref_count 字段时按现有 UserFile 记录补全引用数, 与 CountFileReferences 一致不计入软删除的记录
+func BackfillFileRefCount() error {
+	return DB.Exec("UPDATE files SET ref_count = (SELECT COUNT(*) FROM user_files WHERE user_files.file_id = files.id AND user_files.deleted_at IS NULL)").Error
+}
+
 // DeleteFile 删除文件记录
 func DeleteFile(fileID string) error {
 	return DB.Delete(&File{}, "id = ?", fileID).Error
@@ -41,8 +165,38 @@ func GetAllFiles() ([]File, error) {
 	return files, nil
 }
 
-// GetFileBySha 根据 SHA 获取文件
+// GetFileBySha 根据 SHA 获取文件, 先查询 Redis 中的哈希索引
 func GetFileBySha(sha string) (*File, error) {
+	if id, hit := lookupFileIndex(sha); hit {
+		if id == 0 {
+			return nil, nil
+		}
+		var file File
+		err := DB.First(&file, id).Error
+		if err == nil && file.Sha == sha {
+			return &file, nil
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		// 索引中的记录已经失效
+		unindexFile(sha)
+	}
+
+	file, err := getFileByShaDB(sha)
+	if err != nil {
+		return nil, err
+	}
+	if file != nil {
+		indexFile(sha, file.ID)
+	} else {
+		cacheFileMiss(sha)
+	}
+	return file, nil
+}
+
+// getFileByShaDB 直接从数据库中根据 SHA 获取文件
+func getFileByShaDB(sha string) (*File, error) {
 	var file File
 	if err := DB.Where("sha = ?", sha).First(&file).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -53,20 +207,108 @@ func GetFileBySha(sha string) (*File, error) {
 	return &file, nil
 }
 
-// UpdateFilePathBySha 根据 SHA 更新文件存储路径
-func UpdateFilePathBySha(sha string, newPath string) error {
-	if sha == "" || newPath == "" {
-		return errors.New("sha 和 newPath 不能为空")
+// FileFilter 筛选文件实体的条件, 零值表示不限制
+type FileFilter struct {
+	Backend       string    // 在该后端上有副本
+	UserID        uint      // 被该用户引用
+	MinSize       int64     // 最小大小(字节)
+	MaxSize       int64     // 最大大小(字节)
+	CreatedBefore time.Time // 在该时间之前创建
+	Status        string    // 生命周期状态
+}
+
+// FindFiles 按 ID 分页查询符合条件的文件实体
+func FindFiles(filter FileFilter, afterID uint, limit int) ([]File, error) {
+	query := DB.Where("files.id > ?", afterID)
+	if filter.Backend != "" {
+		query = query.Where("(files.backend = ? OR EXISTS (SELECT 1 FROM file_replicas r WHERE r.file_id = files.id AND r.backend = ? AND r.deleted_at IS NULL))",
+			filter.Backend, filter.Backend)
+	}
+	if filter.UserID != 0 {
+		query = query.Where("EXISTS (SELECT 1 FROM user_files u WHERE u.file_id = files.id AND u.user_id = ? AND u.deleted_at IS NULL)", filter.UserID)
+	}
+	if filter.MinSize > 0 {
+		query = query.Where("files.size >= ?", filter.MinSize)
 	}
+	if filter.MaxSize > 0 {
+		query = query.Where("files.size <= ?", filter.MaxSize)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		query = query.Where("files.created_at < ?", filter.CreatedBefore)
+	}
+	if filter.Status != "" {
+		query = query.Where("files.status = ?", filter.Status)
+	}
+
+	var files []File
+	err := query.Order("files.id").Limit(limit).Find(&files).Error
+	return files, err
+}
 
-	result := DB.Model(&File{}).Where("sha = ?", sha).Update("path", newPath)
-	if result.Error != nil {
-		return result.Error
+// SetFileStatus 更新文件状态, from 不为空时只更新处于这些状态的文件
+func SetFileStatus(sha string, status string, from ...string) error {
+	query := DB.Model(&File{}).Where("sha = ?", sha)
+	if len(from) > 0 {
+		query = query.Where("status IN ?", from)
 	}
+	return query.Update("status", status).Error
+}
 
-	if result.RowsAffected == 0 {
-		return errors.New("未找到匹配的文件，更新失败")
+// UpdateFilePathBySha 根据 SHA 更新文件存储后端及路径并标记为已存储, 原主副本记录替换为新的后端
+func UpdateFilePathBySha(sha string, backend string, newPath string) error {
+	if sha == "" || backend == "" || newPath == "" {
+		return errors.New("sha、backend 和 newPath 不能为空")
 	}
 
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var file File
+		if err := tx.Where("sha = ?", sha).First(&file).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("未找到匹配的文件，更新失败")
+			}
+			return err
+		}
+
+		oldBackend := file.Backend
+		if err := tx.Model(&File{}).Where("id = ?", file.ID).Updates(map[string]interface{}{
+			"backend": backend,
+			"path":    newPath,
+			"status":  FileStatusStored,
+		}).Error; err != nil {
+			return err
+		}
+
+		if oldBackend != backend {
+			if err := DeleteReplica(tx, file.ID, oldBackend); err != nil {
+				return err
+			}
+		}
+		return SaveReplica(tx, &FileReplica{FileID: file.ID, Backend: backend, Path: newPath, Status: "active"})
+	})
+}
+
+// BackfillFileBackend 为新增 backend 字段之前的历史记录补全存储后端
+// 旧版本按 path 前缀区分后端, 本地文件的 path 包含存储根目录, 这里统一转换为相对 key
+func BackfillFileBackend(storageRoot string) error {
+	root := filepath.ToSlash(filepath.Clean(storageRoot)) + "/"
+
+	var files []File
+	if err := DB.Where("backend = 'local' AND (path LIKE ? OR path LIKE ? OR path LIKE ?)", root+"%", "/ceph%", "cos%").
+		Find(&files).Error; err != nil {
+		return err
+	}
+	for _, f := range files {
+		backend, key := "local", strings.TrimPrefix(f.Path, root)
+		switch {
+		case strings.HasPrefix(f.Path, "/ceph"):
+			backend, key = "ceph", f.Path
+		case strings.HasPrefix(f.Path, "cos"):
+			backend, key = "cos", f.Path
+		}
+		if err := DB.Model(&File{}).Where("id = ?", f.ID).
+			Updates(map[string]interface{}{"backend": backend, "path": key}).Error; err != nil {
+			return err
+		}
+	}
 	return nil
 }
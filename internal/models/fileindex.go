@@ -0,0 +1,144 @@
+package models
+
+import (
+	"context"
+	"litedrive/internal/cache/redis"
+	"log"
+	"strconv"
+	"time"
+)
+
+// 文件哈希索引: Redis 哈希 FILE_SHA_INDEX 保存 sha -> File.ID, 在文件创建和回收后维护
+// 索引完整时(重建后设置 FILE_SHA_INDEX_READY)不在索引中即表示文件不存在, 无需查询数据库;
+// Redis 被清空或写入索引失败时索引不再完整, 退回查询数据库并短暂缓存不存在的结果
+
+const (
+	fileIndexKey      = "FILE_SHA_INDEX"
+	fileIndexReadyKey = "FILE_SHA_INDEX_READY"
+	fileMissPrefix    = "FILE_SHA_MISS_"
+	fileMissTTL       = time.Minute
+	fileIndexBatch    = 1000
+)
+
+// lookupFileIndex 在索引中查找文件 ID, hit 为 false 时需要查询数据库, id 为 0 表示文件不存在
+func lookupFileIndex(sha string) (id uint, hit bool) {
+	if redis.RedisCli == nil {
+		return 0, false
+	}
+	ctx, cancel := context.WithTimeout(redis.Ctx, time.Second)
+	defer cancel()
+
+	pipe := redis.RedisCli.Pipeline()
+	idCmd := pipe.HGet(ctx, fileIndexKey, sha)
+	readyCmd := pipe.Exists(ctx, fileIndexReadyKey)
+	missCmd := pipe.Exists(ctx, fileMissPrefix+sha)
+	pipe.Exec(ctx)
+
+	if v, err := idCmd.Result(); err == nil {
+		if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+			return uint(n), true
+		}
+	}
+	if readyCmd.Err() != nil || missCmd.Err() != nil {
+		return 0, false
+	}
+	if readyCmd.Val() > 0 || missCmd.Val() > 0 {
+		return 0, true
+	}
+	return 0, false
+}
+
+// indexFile 文件创建后写入索引, 写入失败时索引不再完整
+func indexFile(sha string, id uint) {
+	if redis.RedisCli == nil {
+		return
+	}
+	pipe := redis.RedisCli.TxPipeline()
+	pipe.HSet(redis.Ctx, fileIndexKey, sha, id)
+	pipe.Del(redis.Ctx, fileMissPrefix+sha)
+	if _, err := pipe.Exec(redis.Ctx); err != nil {
+		log.Printf("写入文件索引失败, 退回数据库查询: %v", err)
+		redis.RedisCli.Del(redis.Ctx, fileIndexReadyKey)
+	}
+}
+
+// unindexFile 文件删除后移出索引
+func unindexFile(sha string) {
+	if redis.RedisCli == nil {
+		return
+	}
+	if err := redis.RedisCli.HDel(redis.Ctx, fileIndexKey, sha).Err(); err != nil {
+		log.Printf("删除文件索引失败: %v", err)
+	}
+}
+
+// cacheFileMiss 索引不完整时缓存文件不存在的查询结果
+func cacheFileMiss(sha string) {
+	if redis.RedisCli == nil {
+		return
+	}
+	redis.RedisCli.Set(redis.Ctx, fileMissPrefix+sha, 1, fileMissTTL)
+}
+
+// RebuildFileIndex 按数据库中的文件重建哈希索引, 返回索引的文件数
+// 重建期间索引标记为不完整, 查询退回数据库; 新创建的文件照常写入索引
+func RebuildFileIndex() (int, error) {
+	if err := redis.RedisCli.Del(redis.Ctx, fileIndexReadyKey).Err(); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	lastID := uint(0)
+	for {
+		var files []File
+		if err := DB.Select("id", "sha").Where("id > ?", lastID).Order("id").Limit(fileIndexBatch).Find(&files).Error; err != nil {
+			return total, err
+		}
+		if len(files) == 0 {
+			break
+		}
+		values := make([]interface{}, 0, len(files)*2)
+		for _, f := range files {
+			values = append(values, f.Sha, f.ID)
+		}
+		if err := redis.RedisCli.HSet(redis.Ctx, fileIndexKey, values...).Err(); err != nil {
+			return total, err
+		}
+		total += len(files)
+		lastID = files[len(files)-1].ID
+	}
+
+	// 清理数据库中已经不存在的文件
+	var cursor uint64
+	for {
+		keys, next, err := redis.RedisCli.HScan(redis.Ctx, fileIndexKey, cursor, "", fileIndexBatch).Result()
+		if err != nil {
+			return total, err
+		}
+		shas := make([]string, 0, len(keys)/2)
+		for i := 0; i < len(keys); i += 2 {
+			shas = append(shas, keys[i])
+		}
+		if len(shas) > 0 {
+			var existing []string
+			if err := DB.Model(&File{}).Where("sha IN ?", shas).Pluck("sha", &existing).Error; err != nil {
+				return total, err
+			}
+			found := make(map[string]bool, len(existing))
+			for _, sha := range existing {
+				found[sha] = true
+			}
+			for _, sha := range shas {
+				if !found[sha] {
+					unindexFile(sha)
+				}
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	return total, redis.RedisCli.Set(redis.Ctx, fileIndexReadyKey, time.Now().Unix(), 0).Err()
+}
@@ -0,0 +1,45 @@
+// Package modelstest 为测试提供临时的 SQLite 数据库, 无需 MySQL 即可测试读写 models.DB 的代码
+package modelstest
+
+import (
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"litedrive/internal/models"
+	"path/filepath"
+	"testing"
+)
+
+// Open 在临时目录中创建数据库并替换 models.DB, 测试结束时恢复
+// SQLite 不支持行锁, FOR UPDATE 被忽略, 并发相关的行为需要在 MySQL 上验证
+func Open(t testing.TB) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "test.db") + "?_busy_timeout=5000&_journal_mode=WAL"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开测试数据库失败: %v", err)
+	}
+	if err := models.Migrate(db); err != nil {
+		t.Fatalf("创建数据表失败: %v", err)
+	}
+
+	prev := models.DB
+	models.DB = db
+	t.Cleanup(func() {
+		models.DB = prev
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// CreateFile 创建已存储的文件实体, 主副本位于 backend 的 path
+func CreateFile(t testing.TB, sha, backend, path string) *models.File {
+	t.Helper()
+	file := &models.File{Sha: sha, Size: 0, Path: path, Backend: backend, Status: models.FileStatusStored}
+	if err := models.DB.Create(file).Error; err != nil {
+		t.Fatalf("创建文件记录失败: %v", err)
+	}
+	return file
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+	"unicode/utf8"
+)
+
+// OutboxMessage 待投递到任务队列的消息, 与产生它的业务记录在同一事务中写入,
+// 由后台任务投递到任务队列, 投递失败时按退避时间重试, 直到投递成功
+type OutboxMessage struct {
+	gorm.Model
+	Topic         string     `gorm:"type:varchar(50);not null"`
+	Payload       string     `gorm:"type:text;not null"`
+	Attempts      int        `gorm:"not null;default:0"`
+	NextAttemptAt time.Time  `gorm:"not null;index"` // 下次投递时间
+	LastError     string     `gorm:"type:varchar(512)"`
+	DispatchedAt  *time.Time `gorm:"index"` // 投递成功的时间, 为空表示尚未投递
+}
+
+// NewOutboxMessage 创建立即投递的消息
+func NewOutboxMessage(topic string, payload []byte) OutboxMessage {
+	return OutboxMessage{Topic: topic, Payload: string(payload), NextAttemptAt: time.Now()}
+}
+
+// RequeueFailedFile 在同一事务中将转移失败的文件重新标记为 staged 并写入新的转移任务
+// 文件已不是 failed 状态(例如已被其他进程重新投递)时不修改, 返回 false
+func RequeueFailedFile(fileID uint, msg *OutboxMessage) (bool, error) {
+	requeued := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&File{}).Where("id = ? AND status = ?", fileID, FileStatusFailed).Update("status", FileStatusStaged)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		if err := tx.Create(msg).Error; err != nil {
+			return err
+		}
+		requeued = true
+		return nil
+	})
+	return requeued, err
+}
+
+// GetDueOutboxMessages 获取到期待投递的消息
+func GetDueOutboxMessages(now time.Time, limit int) ([]OutboxMessage, error) {
+	var messages []OutboxMessage
+	err := DB.Where("dispatched_at IS NULL AND next_attempt_at <= ?", now).
+		Order("id").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+// ClaimOutboxMessage 将消息的下次投递时间推迟到 until, 多个实例同时投递时只有一个成功
+func ClaimOutboxMessage(m *OutboxMessage, until time.Time) (bool, error) {
+	res := DB.Model(&OutboxMessage{}).
+		Where("id = ? AND dispatched_at IS NULL AND next_attempt_at = ?", m.ID, m.NextAttemptAt).
+		Update("next_attempt_at", until)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// ReleaseOutboxClaims 将尚未投递成功的消息的下次投递时间提前到 now, 释放占用及退避
+func ReleaseOutboxClaims(now time.Time) (int64, error) {
+	res := DB.Model(&OutboxMessage{}).Where("dispatched_at IS NULL AND next_attempt_at > ?", now).
+		Update("next_attempt_at", now)
+	return res.RowsAffected, res.Error
+}
+
+// MarkOutboxDispatched 标记消息已投递
+func MarkOutboxDispatched(id uint) error {
+	return DB.Model(&OutboxMessage{}).Where("id = ?", id).Update("dispatched_at", time.Now()).Error
+}
+
+// MarkOutboxFailed 记录投递失败, next 为下次投递时间
+func MarkOutboxFailed(id uint, attempts int, next time.Time, lastError string) error {
+	// 按字符边界截断, 避免截断多字节字符后写入无效的 UTF-8
+	if n := 512; len(lastError) > n {
+		for n > 0 && !utf8.RuneStart(lastError[n]) {
+			n--
+		}
+		lastError = lastError[:n]
+	}
+	return DB.Model(&OutboxMessage{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"attempts":        attempts,
+		"next_attempt_at": next,
+		"last_error":      lastError,
+	}).Error
+}
+
+// PurgeDispatchedOutbox 删除 before 之前投递成功的消息
+func PurgeDispatchedOutbox(before time.Time) (int64, error) {
+	res := DB.Unscoped().Where("dispatched_at < ?", before).Delete(&OutboxMessage{})
+	return res.RowsAffected, res.Error
+}
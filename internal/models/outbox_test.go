@@ -0,0 +1,157 @@
+package models_test
+
+import (
+	"litedrive/internal/models"
+	"litedrive/internal/models/modelstest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func createOutboxMessage(t *testing.T, next time.Time) *models.OutboxMessage {
+	t.Helper()
+	m := models.NewOutboxMessage("transfer", []byte("{}"))
+	m.NextAttemptAt = next
+	if err := models.DB.Create(&m).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &m
+}
+
+func loadOutboxMessage(t *testing.T, id uint) models.OutboxMessage {
+	t.Helper()
+	var m models.OutboxMessage
+	if err := models.DB.First(&m, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestGetDueOutboxMessages(t *testing.T) {
+	modelstest.Open(t)
+	now := time.Now()
+	due := createOutboxMessage(t, now.Add(-time.Minute))
+	createOutboxMessage(t, now.Add(time.Minute))
+	dispatched := createOutboxMessage(t, now.Add(-time.Minute))
+	if err := models.MarkOutboxDispatched(dispatched.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := models.GetDueOutboxMessages(now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].ID != due.ID {
+		t.Fatalf("到期的消息为 %+v, 期望只有消息 %d", messages, due.ID)
+	}
+}
+
+// 同一消息只能被占用一次, 占用后不再到期
+func TestClaimOutboxMessageOnce(t *testing.T) {
+	modelstest.Open(t)
+	now := time.Now()
+	createOutboxMessage(t, now.Add(-time.Minute))
+	messages, err := models.GetDueOutboxMessages(now, 10)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("读取到期的消息失败: %v", err)
+	}
+	// 两个实例读到同一条消息
+	first, second := messages[0], messages[0]
+
+	claimed, err := models.ClaimOutboxMessage(&first, now.Add(time.Minute))
+	if err != nil || !claimed {
+		t.Fatalf("第一次占用: %v, %v", claimed, err)
+	}
+	claimed, err = models.ClaimOutboxMessage(&second, now.Add(time.Minute))
+	if err != nil || claimed {
+		t.Fatalf("第二次占用: %v, %v, 期望失败", claimed, err)
+	}
+	if messages, _ := models.GetDueOutboxMessages(now, 10); len(messages) != 0 {
+		t.Fatalf("占用后仍有 %d 个到期的消息", len(messages))
+	}
+}
+
+func TestClaimOutboxMessageSkipsDispatched(t *testing.T) {
+	modelstest.Open(t)
+	m := createOutboxMessage(t, time.Now().Add(-time.Minute))
+	if err := models.MarkOutboxDispatched(m.ID); err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := models.ClaimOutboxMessage(m, time.Now().Add(time.Minute))
+	if err != nil || claimed {
+		t.Fatalf("占用已投递的消息: %v, %v, 期望失败", claimed, err)
+	}
+}
+
+func TestMarkOutboxFailed(t *testing.T) {
+	modelstest.Open(t)
+	m := createOutboxMessage(t, time.Now())
+	next := time.Now().Add(time.Hour)
+	// 多字节字符跨越 512 字节边界
+	lastError := strings.Repeat("a", 511) + "错误"
+	if err := models.MarkOutboxFailed(m.ID, 2, next, lastError); err != nil {
+		t.Fatal(err)
+	}
+
+	got := loadOutboxMessage(t, m.ID)
+	if got.Attempts != 2 || got.NextAttemptAt.Sub(next).Abs() > time.Second {
+		t.Fatalf("重试次数 %d, 下次投递时间 %v, 期望 2 及 %v", got.Attempts, got.NextAttemptAt, next)
+	}
+	if got.LastError != strings.Repeat("a", 511) || !utf8.ValidString(got.LastError) {
+		t.Fatalf("错误信息截断为 %d 字节, 期望在字符边界截断为 511 字节", len(got.LastError))
+	}
+	if got.DispatchedAt != nil {
+		t.Fatal("投递失败的消息被标记为已投递")
+	}
+}
+
+func TestReleaseOutboxClaims(t *testing.T) {
+	modelstest.Open(t)
+	now := time.Now()
+	claimed := createOutboxMessage(t, now.Add(time.Hour))
+	dispatched := createOutboxMessage(t, now.Add(time.Hour))
+	if err := models.MarkOutboxDispatched(dispatched.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := models.ReleaseOutboxClaims(now)
+	if err != nil || n != 1 {
+		t.Fatalf("释放了 %d 个消息: %v, 期望 1 个", n, err)
+	}
+	messages, err := models.GetDueOutboxMessages(now, 10)
+	if err != nil || len(messages) != 1 || messages[0].ID != claimed.ID {
+		t.Fatalf("释放后到期的消息为 %+v, 期望只有消息 %d", messages, claimed.ID)
+	}
+}
+
+func TestRequeueFailedFile(t *testing.T) {
+	modelstest.Open(t)
+	file := modelstest.CreateFile(t, "sha-requeue", "local", "local/sha-requeue")
+	if err := models.DB.Model(file).Update("status", models.FileStatusFailed).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	msg := models.NewOutboxMessage("transfer", []byte("{}"))
+	requeued, err := models.RequeueFailedFile(file.ID, &msg)
+	if err != nil || !requeued {
+		t.Fatalf("重新投递: %v, %v", requeued, err)
+	}
+	var got models.File
+	models.DB.First(&got, file.ID)
+	if got.Status != models.FileStatusStaged {
+		t.Fatalf("文件状态为 %q, 期望 staged", got.Status)
+	}
+
+	// 已不是 failed 状态的文件不再写入任务
+	again := models.NewOutboxMessage("transfer", []byte("{}"))
+	requeued, err = models.RequeueFailedFile(file.ID, &again)
+	if err != nil || requeued {
+		t.Fatalf("重复重新投递: %v, %v, 期望跳过", requeued, err)
+	}
+	var count int64
+	models.DB.Model(&models.OutboxMessage{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("写入了 %d 个任务, 期望 1 个", count)
+	}
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"time"
+)
+
+// 数据库与存储后端的对账: 查询存储后端对象是否被文件记录引用, 以及清理没有对应对象或文件的记录
+
+// ReferencedObjectKeys 返回 keys 中被文件实体(主副本或副本记录)引用的对象 key
+func ReferencedObjectKeys(backend string, keys []string) (map[string]bool, error) {
+	referenced := make(map[string]bool, len(keys))
+	if len(keys) == 0 {
+		return referenced, nil
+	}
+
+	var paths []string
+	if err := DB.Model(&File{}).Where("backend = ? AND path IN ?", backend, keys).Pluck("path", &paths).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		referenced[p] = true
+	}
+	paths = nil
+	if err := DB.Model(&FileReplica{}).Where("backend = ? AND path IN ?", backend, keys).Pluck("path", &paths).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		referenced[p] = true
+	}
+	return referenced, nil
+}
+
+// GetFilesWithReplicas 按 ID 分页获取文件实体及其副本记录
+func GetFilesWithReplicas(afterID uint, limit int) ([]File, error) {
+	var files []File
+	err := DB.Preload("Replicas").Where("id > ?", afterID).Order("id").Limit(limit).Find(&files).Error
+	return files, err
+}
+
+// MarkFileLost 将所有副本都已丢失的文件实体标记为 lost, 引用它的用户文件保留, 由运维人员处理
+// 文件在 before 之后有更新或已不是 stored 状态时不修改, 返回 false
+func MarkFileLost(fileID uint, before time.Time) (bool, error) {
+	res := DB.Model(&File{}).Where("id = ? AND status = ? AND updated_at < ?", fileID, FileStatusStored, before).
+		Update("status", FileStatusLost)
+	return res.RowsAffected == 1, res.Error
+}
+
+// FindDanglingUserFiles 获取 before 之前创建、所引用的文件实体已不存在的用户文件
+func FindDanglingUserFiles(before time.Time, afterID uint, limit int) ([]UserFile, error) {
+	var files []UserFile
+	err := DB.Where("user_files.id > ? AND user_files.created_at < ?", afterID, before).
+		Where("NOT EXISTS (SELECT 1 FROM files f WHERE f.id = user_files.file_id AND f.deleted_at IS NULL)").
+		Order("user_files.id").Limit(limit).Find(&files).Error
+	return files, err
+}
+
+// DeleteDanglingUserFiles 删除所引用的文件实体已不存在的用户文件, 返回删除的记录数
+func DeleteDanglingUserFiles(ids []uint) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := DB.Unscoped().
+		Where("id IN ? AND NOT EXISTS (SELECT 1 FROM files f WHERE f.id = user_files.file_id AND f.deleted_at IS NULL)", ids).
+		Delete(&UserFile{})
+	return result.RowsAffected, result.Error
+}
+
+// DeleteDanglingReplicas 删除所属文件实体已不存在的副本记录, dryRun 为 true 时只统计
+func DeleteDanglingReplicas(dryRun bool) (int64, error) {
+	query := DB.Unscoped().Where("NOT EXISTS (SELECT 1 FROM files f WHERE f.id = file_replicas.file_id AND f.deleted_at IS NULL)")
+	if dryRun {
+		var count int64
+		err := query.Model(&FileReplica{}).Count(&count).Error
+		return count, err
+	}
+	result := query.Delete(&FileReplica{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FileReplica 文件实体在某个存储后端上的一份副本
+type FileReplica struct {
+	gorm.Model
+	FileID  uint   `json:"fileId" gorm:"not null;uniqueIndex:idx_file_backend"`                   // 所属文件实体
+	Backend string `json:"backend" gorm:"type:varchar(20);not null;uniqueIndex:idx_file_backend"` // 存储后端: local / ceph / cos
+	Path    string `json:"path" gorm:"type:varchar(255);not null"`                                // 副本在该后端中的对象 key
+	Status  string `json:"status" gorm:"type:varchar(20);default:'active'"`
+}
+
+// GetReplicasByFileID 获取文件实体的所有副本
+func GetReplicasByFileID(fileID uint) ([]FileReplica, error) {
+	var replicas []FileReplica
+	if err := DB.Where("file_id = ?", fileID).Order("id").Find(&replicas).Error; err != nil {
+		return nil, err
+	}
+	return replicas, nil
+}
+
+// SaveReplica 记录文件实体在某个后端上的副本, 已存在时更新路径
+func SaveReplica(tx *gorm.DB, replica *FileReplica) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "file_id"}, {Name: "backend"}},
+		DoUpdates: clause.AssignmentColumns([]string{"path", "status", "updated_at"}),
+	}).Create(replica).Error
+}
+
+// MoveReplica 将文件实体在 from 后端上的副本替换为 to 后端上的 newPath, 主副本位于 from 时同时更新主副本
+// 文件已被删除时返回 gorm.ErrRecordNotFound
+func MoveReplica(fileID uint, from, to, newPath string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var file File
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&file, fileID).Error; err != nil {
+			return err
+		}
+		if err := DeleteReplica(tx, file.ID, from); err != nil {
+			return err
+		}
+		if err := SaveReplica(tx, &FileReplica{FileID: file.ID, Backend: to, Path: newPath, Status: "active"}); err != nil {
+			return err
+		}
+		if file.Backend != from {
+			return nil
+		}
+		return tx.Model(&File{}).Where("id = ?", file.ID).Updates(map[string]interface{}{
+			"backend": to,
+			"path":    newPath,
+		}).Error
+	})
+}
+
+// DeleteReplica 删除文件实体在某个后端上的副本记录
+func DeleteReplica(tx *gorm.DB, fileID uint, backend string) error {
+	return tx.Unscoped().Where("file_id = ? AND backend = ?", fileID, backend).Delete(&FileReplica{}).Error
+}
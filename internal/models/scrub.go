@@ -0,0 +1,120 @@
+package models
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// 文件实体完整性校验的结果
+const (
+	VerifyStatusOK       = "ok"       // 所有副本校验通过
+	VerifyStatusRepaired = "repaired" // 损坏或丢失的副本已从其他副本修复
+	VerifyStatusDegraded = "degraded" // 部分副本损坏或丢失且未能修复, 仍有可用副本
+	VerifyStatusCorrupt  = "corrupt"  // 没有可用副本, 至少一个副本内容不一致
+	VerifyStatusMissing  = "missing"  // 没有可用副本, 所有副本都不存在
+)
+
+// 副本状态, 非 active 的副本不再用于读取
+const (
+	ReplicaStatusActive  = "active"
+	ReplicaStatusCorrupt = "corrupt"
+	ReplicaStatusMissing = "missing"
+)
+
+// GetFilesToVerify 获取已写入目标后端且在 before 之前没有校验过的文件, 从未校验的文件在前
+func GetFilesToVerify(before time.Time, limit int) ([]File, error) {
+	var files []File
+	err := DB.Where("status = ? AND (verified_at IS NULL OR verified_at < ?)", FileStatusStored, before).
+		Order("verified_at").Order("id").Limit(limit).Find(&files).Error
+	return files, err
+}
+
+// RecordFileVerification 在同一事务中记录文件的校验结果及各个副本的状态
+// 锁住文件及副本记录后重新确认副本仍然有效, 与迁移(MoveReplica)等修改副本的操作互斥;
+// 已被删除或路径已改变的副本不再记录, 作为 stale 返回; 文件已被删除时返回 gorm.ErrRecordNotFound
+func RecordFileVerification(fileID uint, status string, replicas []FileReplica) (stale []FileReplica, err error) {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		stale = nil
+		var file File
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&file, fileID).Error; err != nil {
+			return err
+		}
+		err := tx.Model(&File{}).Where("id = ?", fileID).Updates(map[string]interface{}{
+			"verified_at":   time.Now(),
+			"verify_status": status,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range replicas {
+			r := replicas[i]
+			var row FileReplica
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("file_id = ? AND backend = ?", fileID, r.Backend).First(&row).Error
+			switch {
+			case err == nil:
+				if row.Path != r.Path {
+					stale = append(stale, r)
+					continue
+				}
+				if err := tx.Model(&row).Update("status", r.Status).Error; err != nil {
+					return err
+				}
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				// 没有副本记录的只能是 File 中的主副本, 主副本已改变或副本记录已被删除时不再创建
+				if r.ID != 0 || file.Backend != r.Backend || file.Path != r.Path {
+					stale = append(stale, r)
+					continue
+				}
+				r.FileID = fileID
+				if err := SaveReplica(tx, &r); err != nil {
+					return err
+				}
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+	return stale, err
+}
+
+// IsReplicaCurrent 副本是否仍属于文件实体, 修复副本前确认, 避免写回已被迁移删除的对象
+func IsReplicaCurrent(fileID uint, backend, path string) (bool, error) {
+	var count int64
+	err := DB.Model(&FileReplica{}).Where("file_id = ? AND backend = ? AND path = ?", fileID, backend, path).Count(&count).Error
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+	err = DB.Model(&File{}).Where("id = ? AND backend = ? AND path = ?", fileID, backend, path).Count(&count).Error
+	return count > 0, err
+}
+
+// CountFilesByVerifyStatus 按校验结果统计文件数, 从未校验的文件计入空字符串
+func CountFilesByVerifyStatus() (map[string]int64, error) {
+	var rows []struct {
+		VerifyStatus string
+		Count        int64
+	}
+	err := DB.Model(&File{}).Select("COALESCE(verify_status, '') AS verify_status, COUNT(*) AS count").Group("verify_status").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, r := range rows {
+		counts[r.VerifyStatus] += r.Count
+	}
+	return counts, nil
+}
+
+// GetFlaggedFiles 获取校验发现问题且未修复的文件, 最近校验的在前
+func GetFlaggedFiles(limit int) ([]File, error) {
+	var files []File
+	err := DB.Preload("Replicas").
+		Where("verify_status IN ?", []string{VerifyStatusDegraded, VerifyStatusCorrupt, VerifyStatusMissing}).
+		Order("verified_at DESC").Limit(limit).Find(&files).Error
+	return files, err
+}
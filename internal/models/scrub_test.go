@@ -0,0 +1,85 @@
+package models_test
+
+import (
+	"litedrive/internal/models"
+	"litedrive/internal/models/modelstest"
+	"testing"
+)
+
+// replicaWithID 校验开始时读取到的副本记录
+func replicaWithID(id uint, r models.FileReplica) models.FileReplica {
+	r.ID = id
+	return r
+}
+
+// 校验期间被迁移的副本不再记录状态, 作为 stale 返回
+func TestRecordFileVerificationReturnsStaleReplicas(t *testing.T) {
+	modelstest.Open(t)
+	file := modelstest.CreateFile(t, "sha-stale", "local", "a/b/sha-stale")
+	moved := models.FileReplica{FileID: file.ID, Backend: "ceph", Path: "ceph/new", Status: models.ReplicaStatusActive}
+	if err := models.SaveReplica(models.DB, &moved); err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := models.RecordFileVerification(file.ID, models.VerifyStatusDegraded, []models.FileReplica{
+		{Backend: "local", Path: "a/b/sha-stale", Status: models.ReplicaStatusActive},
+		replicaWithID(moved.ID, models.FileReplica{Backend: "ceph", Path: "ceph/old", Status: models.ReplicaStatusCorrupt}),
+		replicaWithID(999, models.FileReplica{Backend: "cos", Path: "cos/deleted", Status: models.ReplicaStatusMissing}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 2 || stale[0].Path != "ceph/old" || stale[1].Path != "cos/deleted" {
+		t.Fatalf("stale 副本为 %+v, 期望 ceph/old 及 cos/deleted", stale)
+	}
+
+	replicas, err := models.GetReplicasByFileID(file.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := make(map[string]string)
+	for _, r := range replicas {
+		statuses[r.Backend+":"+r.Path] = r.Status
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("副本记录为 %v, 期望 local 主副本及 ceph 副本", statuses)
+	}
+	// 没有副本记录的主副本在校验后补全记录
+	if statuses["local:a/b/sha-stale"] != models.ReplicaStatusActive {
+		t.Fatalf("主副本状态为 %q, 期望 active", statuses["local:a/b/sha-stale"])
+	}
+	if statuses["ceph:ceph/new"] != models.ReplicaStatusActive {
+		t.Fatalf("迁移后的副本状态为 %q, 不应被旧路径的校验结果修改", statuses["ceph:ceph/new"])
+	}
+
+	var got models.File
+	models.DB.First(&got, file.ID)
+	if got.VerifyStatus != models.VerifyStatusDegraded || got.VerifiedAt == nil {
+		t.Fatalf("校验结果为 %q, 期望 %q", got.VerifyStatus, models.VerifyStatusDegraded)
+	}
+}
+
+// 主副本已迁移到其他后端时不再为旧的主副本创建记录
+func TestRecordFileVerificationSkipsMovedPrimary(t *testing.T) {
+	modelstest.Open(t)
+	file := modelstest.CreateFile(t, "sha-moved", "cos", "cos/sha-moved")
+
+	stale, err := models.RecordFileVerification(file.ID, models.VerifyStatusOK, []models.FileReplica{
+		{Backend: "local", Path: "a/b/sha-moved", Status: models.ReplicaStatusActive},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 1 {
+		t.Fatalf("stale 副本数为 %d, 期望 1", len(stale))
+	}
+	if replicas, _ := models.GetReplicasByFileID(file.ID); len(replicas) != 0 {
+		t.Fatalf("为已迁移的主副本创建了记录 %+v", replicas)
+	}
+	if current, err := models.IsReplicaCurrent(file.ID, "local", "a/b/sha-moved"); err != nil || current {
+		t.Fatalf("已迁移的主副本 IsReplicaCurrent 返回 %v, %v", current, err)
+	}
+	if current, err := models.IsReplicaCurrent(file.ID, "cos", "cos/sha-moved"); err != nil || !current {
+		t.Fatalf("当前主副本 IsReplicaCurrent 返回 %v, %v", current, err)
+	}
+}
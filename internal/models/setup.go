@@ -20,8 +20,28 @@ func InitDatabase() {
 		log.Println("Connected to database successfully")
 	}
 
-	DB.AutoMigrate(&User{}, &File{}, &UserFile{}, &UserDir{})
+	// 引用数字段新增时需要按现有记录补全
+	hasRefCount := DB.Migrator().HasColumn(&File{}, "RefCount")
 
+	if err := Migrate(DB); err != nil {
+		log.Printf("更新数据表失败: %v", err)
+	}
+
+	if !hasRefCount {
+		if err := BackfillFileRefCount(); err != nil {
+			log.Printf("补全文件引用数失败: %v", err)
+		}
+	}
+
+	if err := BackfillFileBackend(config.Storage.Root); err != nil {
+		log.Printf("补全文件存储后端失败: %v", err)
+	}
+
+}
+
+// Migrate 创建或更新所有数据表
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&User{}, &File{}, &FileReplica{}, &UserFile{}, &UserDir{}, &OutboxMessage{})
 }
 
 // CloseDatabase 关闭数据库连接
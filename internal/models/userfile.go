@@ -3,6 +3,7 @@ package models
 import (
 	"errors"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserFile struct {
@@ -19,9 +20,50 @@ type UserFile struct {
 	//Dir  UserDir `gorm:"foreignKey:DirID;references:ID"`
 }
 
-// OnUserFileUploadFinished: 更新用户文件表
-func (u *UserFile) OnUserFileUploadFinished() error {
-	return DB.Create(u).Error
+// AttachUserFile 将文件关联到用户并增加文件的引用数, 同一用户以同名保存同一文件时复用已有记录
+func (u *UserFile) AttachUserFile() error {
+	return DB.Transaction(u.attach)
+}
+
+func (u *UserFile) attach(tx *gorm.DB) error {
+	// 锁住文件记录, 与回收任务互斥
+	var file File
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&file, u.FileID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("文件已被删除, 请重新上传")
+		}
+		return err
+	}
+
+	var existing UserFile
+	err := tx.Where("user_id = ? AND file_id = ? AND file_name = ?", u.UserID, u.FileID, u.FileName).First(&existing).Error
+	if err == nil {
+		*u = existing
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if err := tx.Create(u).Error; err != nil {
+		return err
+	}
+	return tx.Model(&File{}).Where("id = ?", u.FileID).Update("ref_count", gorm.Expr("ref_count + 1")).Error
+}
+
+// DetachUserFile 删除用户文件并减少文件的引用数, 引用数为 0 的文件由回收任务删除
+func (u *UserFile) DetachUserFile() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Unscoped().Where("id = ? AND user_id = ?", u.ID, u.UserID).Delete(&UserFile{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Model(&File{}).Where("id = ? AND ref_count > 0", u.FileID).
+			Update("ref_count", gorm.Expr("ref_count - 1")).Error
+	})
 }
 
 // QueryUserFileMetas: 获取指定用户的所有文件列表
@@ -33,6 +75,18 @@ func QueryUserFileMetas(userid uint, limit int) ([]UserFile, error) {
 	return files, nil
 }
 
+// QueryUserPendingTransfers 获取指定用户尚未完成转移的文件
+func QueryUserPendingTransfers(userid uint) ([]UserFile, error) {
+	var files []UserFile
+	err := DB.Joins("File").
+		Where("user_files.user_id = ? AND File.status IN ?", userid, []string{FileStatusStaged, FileStatusTransferring, FileStatusFailed}).
+		Order("user_files.id").Find(&files).Error
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
 // GetUserFileByIDAndUser: 查询用户文件记录
 func (u *UserFile) GetUserFileByIDFileNameAndUser() (*UserFile, error) {
 	var userFile UserFile
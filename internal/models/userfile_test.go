@@ -0,0 +1,172 @@
+package models_test
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"litedrive/internal/models"
+	"litedrive/internal/models/modelstest"
+	"testing"
+)
+
+func refCount(t *testing.T, fileID uint) int64 {
+	t.Helper()
+	var file models.File
+	if err := models.DB.First(&file, fileID).Error; err != nil {
+		t.Fatalf("读取文件 %d 失败: %v", fileID, err)
+	}
+	return file.RefCount
+}
+
+func TestAttachDetachMaintainsRefCount(t *testing.T) {
+	modelstest.Open(t)
+	file := modelstest.CreateFile(t, "sha-attach", "local", "a/b/sha-attach")
+
+	first := &models.UserFile{UserID: 1, FileID: file.ID, FileName: "a.txt"}
+	if err := first.AttachUserFile(); err != nil {
+		t.Fatal(err)
+	}
+	// 同一用户以同名再次保存时复用已有记录, 不增加引用数
+	again := &models.UserFile{UserID: 1, FileID: file.ID, FileName: "a.txt"}
+	if err := again.AttachUserFile(); err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != first.ID {
+		t.Fatalf("重复关联创建了新记录 %d, 期望复用 %d", again.ID, first.ID)
+	}
+	second := &models.UserFile{UserID: 2, FileID: file.ID, FileName: "a.txt"}
+	if err := second.AttachUserFile(); err != nil {
+		t.Fatal(err)
+	}
+	if n := refCount(t, file.ID); n != 2 {
+		t.Fatalf("关联后引用数为 %d, 期望 2", n)
+	}
+
+	if err := first.DetachUserFile(); err != nil {
+		t.Fatal(err)
+	}
+	// 重复删除不再减少引用数
+	if err := first.DetachUserFile(); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("重复删除返回 %v, 期望 gorm.ErrRecordNotFound", err)
+	}
+	if n := refCount(t, file.ID); n != 1 {
+		t.Fatalf("删除一个引用后引用数为 %d, 期望 1", n)
+	}
+	if err := second.DetachUserFile(); err != nil {
+		t.Fatal(err)
+	}
+	if n := refCount(t, file.ID); n != 0 {
+		t.Fatalf("删除所有引用后引用数为 %d, 期望 0", n)
+	}
+}
+
+func TestDetachRequiresOwner(t *testing.T) {
+	modelstest.Open(t)
+	file := modelstest.CreateFile(t, "sha-owner", "local", "a/b/sha-owner")
+	u := &models.UserFile{UserID: 1, FileID: file.ID, FileName: "a.txt"}
+	if err := u.AttachUserFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	other := &models.UserFile{UserID: 2, FileID: file.ID}
+	other.ID = u.ID
+	if err := other.DetachUserFile(); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("其他用户删除返回 %v, 期望 gorm.ErrRecordNotFound", err)
+	}
+	if n := refCount(t, file.ID); n != 1 {
+		t.Fatalf("引用数为 %d, 期望 1", n)
+	}
+}
+
+func TestAttachRejectsMissingFile(t *testing.T) {
+	modelstest.Open(t)
+	u := &models.UserFile{UserID: 1, FileID: 404, FileName: "a.txt"}
+	if err := u.AttachUserFile(); err == nil {
+		t.Fatal("关联不存在的文件应当失败")
+	}
+}
+
+// 引用数为 0 但仍有用户文件时不回收, 并按实际记录修正引用数
+func TestReapFileKeepsReferencedFile(t *testing.T) {
+	modelstest.Open(t)
+	file := modelstest.CreateFile(t, "sha-drift", "local", "a/b/sha-drift")
+	if err := models.DB.Create(&models.UserFile{UserID: 1, FileID: file.ID, FileName: "a.txt"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	reaped, err := models.ReapFile(file.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reaped != nil {
+		t.Fatal("仍被引用的文件被回收")
+	}
+	if n := refCount(t, file.ID); n != 1 {
+		t.Fatalf("修正后的引用数为 %d, 期望 1", n)
+	}
+}
+
+func TestReapFileDeletesRecordsAndReturnsReplicas(t *testing.T) {
+	modelstest.Open(t)
+	file := modelstest.CreateFile(t, "sha-reap", "local", "a/b/sha-reap")
+	for _, r := range []models.FileReplica{
+		{FileID: file.ID, Backend: "local", Path: "a/b/sha-reap"},
+		{FileID: file.ID, Backend: "ceph", Path: "ceph/sha-reap"},
+	} {
+		if err := models.SaveReplica(models.DB, &r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reaped, err := models.ReapFile(file.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reaped == nil {
+		t.Fatal("没有引用的文件未被回收")
+	}
+	if len(reaped.Replicas) != 2 {
+		t.Fatalf("返回的副本数为 %d, 期望 2", len(reaped.Replicas))
+	}
+
+	var count int64
+	models.DB.Unscoped().Model(&models.File{}).Where("id = ?", file.ID).Count(&count)
+	if count != 0 {
+		t.Fatal("文件记录未删除")
+	}
+	models.DB.Unscoped().Model(&models.FileReplica{}).Where("file_id = ?", file.ID).Count(&count)
+	if count != 0 {
+		t.Fatal("副本记录未删除")
+	}
+
+	// 已回收的文件再次回收时不做处理
+	if reaped, err := models.ReapFile(file.ID); err != nil || reaped != nil {
+		t.Fatalf("重复回收返回 %v, %v", reaped, err)
+	}
+}
+
+func TestBackfillFileRefCountIgnoresSoftDeleted(t *testing.T) {
+	modelstest.Open(t)
+	live := modelstest.CreateFile(t, "sha-live", "local", "a/b/sha-live")
+	deleted := modelstest.CreateFile(t, "sha-deleted", "local", "a/b/sha-deleted")
+
+	if err := models.DB.Create(&models.UserFile{UserID: 1, FileID: live.ID, FileName: "a.txt"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	soft := &models.UserFile{UserID: 1, FileID: deleted.ID, FileName: "b.txt"}
+	if err := models.DB.Create(soft).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := models.DB.Delete(soft).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := models.BackfillFileRefCount(); err != nil {
+		t.Fatal(err)
+	}
+	if n := refCount(t, live.ID); n != 1 {
+		t.Fatalf("引用数为 %d, 期望 1", n)
+	}
+	if n := refCount(t, deleted.ID); n != 0 {
+		t.Fatalf("只有软删除引用的文件引用数为 %d, 期望 0", n)
+	}
+}
@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"litedrive/internal/cache/rabbitmq"
+	"litedrive/internal/utils"
+	"log"
+	"sync"
+	"time"
+)
+
+// 进程内任务队列, 任务保存在内存中, 进程退出时尚未处理或等待重试的任务会丢失,
+// 通过发件箱投递的任务在处理完成后才标记为已投递, 重启后由发件箱重新投递
+
+const defaultMemoryBufferSize = 1024
+
+// MemoryOptions 进程内任务队列的参数
+type MemoryOptions struct {
+	MaxRetries  int           // 最大重试次数
+	RetryDelay  time.Duration // 首次重试的延迟, 之后每次翻倍
+	Concurrency int           // 每种任务同时处理的数量
+	BufferSize  int           // 每种任务最多排队的数量, 超过时投递失败
+}
+
+type memoryJob struct {
+	payload []byte
+	attempt int
+	done    func() // 处理成功或重试耗尽后调用, 可以为 nil
+}
+
+// MemoryQueue 进程内任务队列
+type MemoryQueue struct {
+	opts MemoryOptions
+
+	mu     sync.Mutex
+	topics map[string]chan memoryJob
+	closed bool
+}
+
+var (
+	_ Queue            = (*MemoryQueue)(nil)
+	_ trackedPublisher = (*MemoryQueue)(nil)
+)
+
+// NewMemoryQueue 创建进程内任务队列
+func NewMemoryQueue(opts MemoryOptions) *MemoryQueue {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultMemoryBufferSize
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Second
+	}
+	return &MemoryQueue{opts: opts, topics: make(map[string]chan memoryJob)}
+}
+
+// memoryOptionsFromConfig 重试及并发参数与 RabbitMQ 实现共用配置
+func memoryOptionsFromConfig() MemoryOptions {
+	var opts MemoryOptions
+	if tc, err := rabbitmq.LoadTransferConfig(); err == nil {
+		opts.MaxRetries = tc.MaxRetries
+		opts.RetryDelay = tc.RetryDelay
+		opts.Concurrency = tc.Concurrency
+	}
+	if config, err := utils.LoadConfig(); err == nil {
+		opts.BufferSize = config.Queue.BufferSize
+	}
+	return opts
+}
+
+func (q *MemoryQueue) topic(name string) chan memoryJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ch, ok := q.topics[name]
+	if !ok {
+		ch = make(chan memoryJob, q.opts.BufferSize)
+		q.topics[name] = ch
+	}
+	return ch
+}
+
+func (q *MemoryQueue) Publish(ctx context.Context, topic string, payload []byte) error {
+	return q.enqueue(topic, memoryJob{payload: payload})
+}
+
+func (q *MemoryQueue) PublishTracked(ctx context.Context, topic string, payload []byte, done func()) error {
+	return q.enqueue(topic, memoryJob{payload: payload, done: done})
+}
+
+func (q *MemoryQueue) enqueue(topic string, job memoryJob) error {
+	q.mu.Lock()
+	closed := q.closed
+	q.mu.Unlock()
+	if closed {
+		return fmt.Errorf("%w: 队列已关闭", ErrUnavailable)
+	}
+
+	select {
+	case q.topic(topic) <- job:
+		return nil
+	default:
+		return fmt.Errorf("%w: 队列已满", ErrUnavailable)
+	}
+}
+
+func (q *MemoryQueue) Consume(ctx context.Context, topic string, handler Handler, onDeadLetter DeadLetterHandler) error {
+	jobs := q.topic(topic)
+	var wg sync.WaitGroup
+	for i := 0; i < q.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-jobs:
+					q.handle(topic, job, handler, onDeadLetter)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// handle 处理一个任务, 失败时延迟后重新排队, 重试耗尽时交给 onDeadLetter
+// 重新排队失败(例如队列已关闭)的任务不调用 done, 由发件箱重新投递
+func (q *MemoryQueue) handle(topic string, job memoryJob, handler Handler, onDeadLetter DeadLetterHandler) {
+	err := handler(job.payload)
+	if err == nil {
+		if job.done != nil {
+			job.done()
+		}
+		return
+	}
+
+	job.attempt++
+	if job.attempt > q.opts.MaxRetries {
+		log.Printf("任务重试 %d 次后仍然失败: %v", q.opts.MaxRetries, err)
+		if onDeadLetter != nil {
+			onDeadLetter(job.payload, err)
+		}
+		if job.done != nil {
+			job.done()
+		}
+		return
+	}
+
+	delay := q.opts.RetryDelay << (job.attempt - 1)
+	log.Printf("任务处理失败, %s 后第 %d 次重试: %v", delay, job.attempt, err)
+	time.AfterFunc(delay, func() {
+		if err := q.enqueue(topic, job); err != nil {
+			log.Printf("任务重新排队失败: %v", err)
+			if onDeadLetter != nil {
+				onDeadLetter(job.payload, err)
+			}
+		}
+	})
+}
+
+func (q *MemoryQueue) InProcess() bool {
+	return true
+}
+
+func (q *MemoryQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+}
@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testTimeout = 5 * time.Second
+
+// consume 在后台消费 topic, 测试结束时停止消费并等待消费者退出
+func consume(t *testing.T, q *MemoryQueue, topic string, handler Handler, onDeadLetter DeadLetterHandler) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.Consume(ctx, topic, handler, onDeadLetter)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		q.Close()
+	})
+}
+
+func TestMemoryQueuePublish(t *testing.T) {
+	q := NewMemoryQueue(MemoryOptions{MaxRetries: 3, RetryDelay: time.Millisecond})
+	received := make(chan []byte, 1)
+	consume(t, q, TopicTransfer, func(payload []byte) error {
+		received <- payload
+		return nil
+	}, nil)
+
+	if err := q.Publish(context.Background(), TopicTransfer, []byte("job")); err != nil {
+		t.Fatalf("投递任务失败: %v", err)
+	}
+	select {
+	case payload := <-received:
+		if string(payload) != "job" {
+			t.Fatalf("收到的任务为 %q, 期望 %q", payload, "job")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("等待任务超时")
+	}
+}
+
+func TestMemoryQueueRetriesFailedJob(t *testing.T) {
+	q := NewMemoryQueue(MemoryOptions{MaxRetries: 3, RetryDelay: time.Millisecond})
+	var calls atomic.Int32
+	succeeded := make(chan struct{})
+	consume(t, q, TopicTransfer, func(payload []byte) error {
+		// 前两次失败, 第三次成功
+		if calls.Add(1) < 3 {
+			return errors.New("暂时失败")
+		}
+		close(succeeded)
+		return nil
+	}, func(payload []byte, cause error) {
+		t.Errorf("重试未耗尽时不应进入死信: %v", cause)
+	})
+
+	if err := q.Publish(context.Background(), TopicTransfer, []byte("job")); err != nil {
+		t.Fatalf("投递任务失败: %v", err)
+	}
+	select {
+	case <-succeeded:
+	case <-time.After(testTimeout):
+		t.Fatalf("等待重试成功超时, 已处理 %d 次", calls.Load())
+	}
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("处理次数为 %d, 期望 3", n)
+	}
+}
+
+func TestMemoryQueueDeadLettersAfterRetries(t *testing.T) {
+	const maxRetries = 2
+	q := NewMemoryQueue(MemoryOptions{MaxRetries: maxRetries, RetryDelay: time.Millisecond})
+	var calls atomic.Int32
+	type deadLetter struct {
+		payload []byte
+		cause   error
+	}
+	dead := make(chan deadLetter, 1)
+	cause := errors.New("始终失败")
+	consume(t, q, TopicTransfer, func(payload []byte) error {
+		calls.Add(1)
+		return cause
+	}, func(payload []byte, err error) {
+		dead <- deadLetter{payload: payload, cause: err}
+	})
+
+	if err := q.Publish(context.Background(), TopicTransfer, []byte("job")); err != nil {
+		t.Fatalf("投递任务失败: %v", err)
+	}
+	select {
+	case d := <-dead:
+		if string(d.payload) != "job" {
+			t.Fatalf("死信任务为 %q, 期望 %q", d.payload, "job")
+		}
+		if !errors.Is(d.cause, cause) {
+			t.Fatalf("死信原因为 %v, 期望 %v", d.cause, cause)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("等待死信超时, 已处理 %d 次", calls.Load())
+	}
+	// 首次处理加上 maxRetries 次重试
+	if n := calls.Load(); n != maxRetries+1 {
+		t.Fatalf("处理次数为 %d, 期望 %d", n, maxRetries+1)
+	}
+}
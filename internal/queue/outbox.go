@@ -0,0 +1,139 @@
+package queue
+
+import (
+	"context"
+	"litedrive/internal/models"
+	"litedrive/internal/utils"
+	"log"
+	"time"
+)
+
+// 发件箱投递: 任务先随业务记录写入 outbox_messages 表, 由后台任务投递到任务队列,
+// 队列不可用时按退避时间无限重试, 保证任务不会因为投递失败而丢失
+
+const (
+	defaultOutboxInterval = 5 * time.Second
+	outboxBatch           = 100
+	// 投递期间占用消息的时间, 超过后其他实例可以重新投递
+	outboxClaimLease = time.Minute
+	// 进程内队列处理任务期间占用消息的时间, 需要大于任务排队及重试的总时长, 进程重启时释放
+	inProcessClaimLease = 24 * time.Hour
+	outboxMaxBackoff    = 10 * time.Minute
+	// 投递成功的消息保留的时间
+	outboxRetention = 7 * 24 * time.Hour
+)
+
+// outboxWake 新消息写入后唤醒投递任务, 无需等待下一个周期
+var outboxWake = make(chan struct{}, 1)
+
+// NotifyOutbox 通知投递任务有新的消息
+func NotifyOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// StartOutboxDispatcher 启动后台投递任务
+func StartOutboxDispatcher() {
+	interval := defaultOutboxInterval
+	if config, err := utils.LoadConfig(); err == nil && config.Queue.OutboxInterval > 0 {
+		interval = time.Duration(config.Queue.OutboxInterval) * time.Second
+	}
+
+	// 进程内队列的任务在进程退出时丢失, 启动时释放上次运行占用但未处理完成的消息, 立即重新投递
+	if Default().InProcess() {
+		if n, err := models.ReleaseOutboxClaims(time.Now()); err != nil {
+			log.Printf("释放未处理完成的消息失败: %v", err)
+		} else if n > 0 {
+			log.Printf("重新投递上次运行未处理完成的消息 %d 个", n)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		lastPurge := time.Time{}
+		for {
+			select {
+			case <-ticker.C:
+			case <-outboxWake:
+			}
+			DispatchOutbox()
+			if time.Since(lastPurge) > time.Hour {
+				if _, err := models.PurgeDispatchedOutbox(time.Now().Add(-outboxRetention)); err != nil {
+					log.Printf("清理已投递的消息失败: %v", err)
+				}
+				lastPurge = time.Now()
+			}
+		}
+	}()
+	log.Println("任务投递已启动, 间隔:", interval)
+}
+
+// DispatchOutbox 投递到期的消息, 返回投递成功的数量
+func DispatchOutbox() int {
+	dispatched := 0
+	for {
+		messages, err := models.GetDueOutboxMessages(time.Now(), outboxBatch)
+		if err != nil {
+			log.Printf("读取待投递的消息失败: %v", err)
+			return dispatched
+		}
+		for i := range messages {
+			if dispatchOutboxMessage(&messages[i]) {
+				dispatched++
+			}
+		}
+		if len(messages) < outboxBatch {
+			return dispatched
+		}
+	}
+}
+
+// dispatchOutboxMessage 投递一个消息, 进程内队列在任务处理完成后才标记消息已投递
+func dispatchOutboxMessage(m *models.OutboxMessage) bool {
+	q := Default()
+	tracked, inProcess := q.(trackedPublisher)
+	lease := outboxClaimLease
+	if inProcess {
+		lease = inProcessClaimLease
+	}
+	claimed, err := models.ClaimOutboxMessage(m, time.Now().Add(lease))
+	if err != nil || !claimed {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if inProcess {
+		id := m.ID
+		err = tracked.PublishTracked(ctx, m.Topic, []byte(m.Payload), func() { markOutboxDispatched(id) })
+	} else {
+		err = q.Publish(ctx, m.Topic, []byte(m.Payload))
+	}
+	if err != nil {
+		attempts := m.Attempts + 1
+		backoff := time.Second << min(attempts, 10)
+		if backoff > outboxMaxBackoff {
+			backoff = outboxMaxBackoff
+		}
+		log.Printf("投递消息 %d 失败, %s 后第 %d 次重试: %v", m.ID, backoff, attempts, err)
+		if err := models.MarkOutboxFailed(m.ID, attempts, time.Now().Add(backoff), err.Error()); err != nil {
+			log.Printf("记录消息 %d 投递失败: %v", m.ID, err)
+		}
+		return false
+	}
+
+	if !inProcess {
+		markOutboxDispatched(m.ID)
+	}
+	return true
+}
+
+// markOutboxDispatched 标记失败时消息会在占用到期后重复投递, 消费者需要能处理重复的任务
+func markOutboxDispatched(id uint) {
+	if err := models.MarkOutboxDispatched(id); err != nil {
+		log.Printf("标记消息 %d 已投递失败: %v", id, err)
+	}
+}
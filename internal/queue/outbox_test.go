@@ -0,0 +1,135 @@
+package queue
+
+import (
+	"errors"
+	"litedrive/internal/models"
+	"litedrive/internal/models/modelstest"
+	"testing"
+	"time"
+)
+
+// useQueue 替换默认任务队列, 测试结束时恢复
+func useQueue(t *testing.T, q Queue) {
+	t.Helper()
+	defaultMu.RLock()
+	prev := defaultQueue
+	defaultMu.RUnlock()
+	SetDefault(q)
+	t.Cleanup(func() { SetDefault(prev) })
+}
+
+func createOutboxMessage(t *testing.T) uint {
+	t.Helper()
+	m := models.NewOutboxMessage(TopicTransfer, []byte("job"))
+	m.NextAttemptAt = time.Now().Add(-time.Second)
+	if err := models.DB.Create(&m).Error; err != nil {
+		t.Fatal(err)
+	}
+	return m.ID
+}
+
+func loadOutboxMessage(t *testing.T, id uint) models.OutboxMessage {
+	t.Helper()
+	var m models.OutboxMessage
+	if err := models.DB.First(&m, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// 进程内队列在任务处理成功后才标记消息已投递
+func TestDispatchOutboxMarksAfterHandled(t *testing.T) {
+	modelstest.Open(t)
+	q := NewMemoryQueue(MemoryOptions{MaxRetries: 3, RetryDelay: time.Millisecond})
+	useQueue(t, q)
+
+	release := make(chan struct{})
+	handled := make(chan struct{})
+	consume(t, q, TopicTransfer, func(payload []byte) error {
+		<-release
+		close(handled)
+		return nil
+	}, nil)
+
+	id := createOutboxMessage(t)
+	if n := DispatchOutbox(); n != 1 {
+		t.Fatalf("投递了 %d 个消息, 期望 1 个", n)
+	}
+	if m := loadOutboxMessage(t, id); m.DispatchedAt != nil {
+		t.Fatal("任务处理完成前消息已被标记为已投递")
+	}
+	// 占用期间不会重复投递
+	if n := DispatchOutbox(); n != 0 {
+		t.Fatalf("重复投递了 %d 个消息", n)
+	}
+
+	close(release)
+	select {
+	case <-handled:
+	case <-time.After(testTimeout):
+		t.Fatal("等待任务超时")
+	}
+	deadline := time.Now().Add(testTimeout)
+	for loadOutboxMessage(t, id).DispatchedAt == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("任务处理完成后消息未被标记为已投递")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 重试耗尽的任务交给死信处理后同样标记为已投递, 不再重复投递
+func TestDispatchOutboxMarksDeadLetter(t *testing.T) {
+	modelstest.Open(t)
+	q := NewMemoryQueue(MemoryOptions{MaxRetries: 1, RetryDelay: time.Millisecond})
+	useQueue(t, q)
+
+	deadLetters := make(chan struct{}, 1)
+	consume(t, q, TopicTransfer, func(payload []byte) error {
+		return errors.New("处理失败")
+	}, func(payload []byte, cause error) {
+		deadLetters <- struct{}{}
+	})
+
+	id := createOutboxMessage(t)
+	DispatchOutbox()
+	select {
+	case <-deadLetters:
+	case <-time.After(testTimeout):
+		t.Fatal("等待死信超时")
+	}
+	deadline := time.Now().Add(testTimeout)
+	for loadOutboxMessage(t, id).DispatchedAt == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("重试耗尽后消息未被标记为已投递")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 队列不可用时记录失败并按退避时间重试
+func TestDispatchOutboxRetriesUnavailableQueue(t *testing.T) {
+	modelstest.Open(t)
+	q := NewMemoryQueue(MemoryOptions{})
+	q.Close()
+	useQueue(t, q)
+
+	id := createOutboxMessage(t)
+	if n := DispatchOutbox(); n != 0 {
+		t.Fatalf("投递了 %d 个消息, 期望 0 个", n)
+	}
+	m := loadOutboxMessage(t, id)
+	if m.DispatchedAt != nil {
+		t.Fatal("投递失败的消息被标记为已投递")
+	}
+	if m.Attempts != 1 || m.LastError == "" {
+		t.Fatalf("重试次数 %d, 错误信息 %q, 期望记录第 1 次失败", m.Attempts, m.LastError)
+	}
+	if !m.NextAttemptAt.After(time.Now()) {
+		t.Fatal("下次投递时间没有推迟")
+	}
+	// 退避期间不会重新投递
+	if messages, _ := models.GetDueOutboxMessages(time.Now(), 10); len(messages) != 0 {
+		t.Fatalf("退避期间仍有 %d 个到期的消息", len(messages))
+	}
+}
@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"litedrive/internal/cache/rabbitmq"
+	"litedrive/internal/utils"
+	"log"
+	"sync"
+)
+
+// 后台任务队列: 上传服务投递任务, 工作进程按任务类型消费
+// rabbitmq 实现由独立的工作进程消费, memory 实现在当前进程内消费, 无需消息代理, 适合小规模部署及测试
+
+// 任务类型
+const (
+	// TopicTransfer 将本地暂存的文件转移到目标存储后端
+	TopicTransfer = rabbitmq.TransferTopic
+)
+
+// 任务队列实现
+const (
+	BackendRabbitMQ = "rabbitmq"
+	BackendMemory   = "memory"
+)
+
+// ErrUnavailable 任务队列暂时不可用, 任务未投递
+var ErrUnavailable = errors.New("任务队列不可用")
+
+// Handler 处理一个任务, 返回错误时按配置重试
+type Handler func(payload []byte) error
+
+// DeadLetterHandler 任务重试耗尽后调用
+type DeadLetterHandler func(payload []byte, cause error)
+
+// Queue 任务队列接口
+type Queue interface {
+	// Publish 投递任务, 队列不可用时返回错误, 不会阻塞或退出进程
+	Publish(ctx context.Context, topic string, payload []byte) error
+	// Consume 处理指定类型的任务, 阻塞到 ctx 取消, 返回前等待处理中的任务完成
+	// onDeadLetter 可以为 nil
+	Consume(ctx context.Context, topic string, handler Handler, onDeadLetter DeadLetterHandler) error
+	// InProcess 任务是否在当前进程内消费, 为 true 时需要在当前进程启动消费者
+	InProcess() bool
+	// Close 释放队列占用的连接
+	Close()
+}
+
+// trackedPublisher 进程内队列在任务处理成功或重试耗尽后调用 done,
+// 发件箱据此在任务处理完成后才标记消息已投递, 避免进程退出时丢失任务
+type trackedPublisher interface {
+	PublishTracked(ctx context.Context, topic string, payload []byte, done func()) error
+}
+
+var (
+	defaultMu    sync.RWMutex
+	defaultQueue Queue
+)
+
+// Init 按配置创建默认任务队列, 未配置时使用 rabbitmq
+func Init() Queue {
+	backend := BackendRabbitMQ
+	if config, err := utils.LoadConfig(); err == nil && config.Queue.Backend != "" {
+		backend = config.Queue.Backend
+	}
+
+	var q Queue
+	switch backend {
+	case BackendMemory:
+		q = NewMemoryQueue(memoryOptionsFromConfig())
+	case BackendRabbitMQ:
+		q = NewRabbitQueue()
+	default:
+		log.Printf("未知的任务队列 %s, 使用 %s", backend, BackendRabbitMQ)
+		q = NewRabbitQueue()
+	}
+	SetDefault(q)
+	log.Println("任务队列:", backend)
+	return q
+}
+
+// SetDefault 替换默认任务队列
+func SetDefault(q Queue) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultQueue = q
+}
+
+// Default 返回默认任务队列, 未初始化时按配置创建
+func Default() Queue {
+	defaultMu.RLock()
+	q := defaultQueue
+	defaultMu.RUnlock()
+	if q != nil {
+		return q
+	}
+	return Init()
+}
+
+// Publish 向默认任务队列投递任务
+func Publish(ctx context.Context, topic string, payload []byte) error {
+	return Default().Publish(ctx, topic, payload)
+}
+
+// Close 关闭默认任务队列
+func Close() {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultQueue != nil {
+		defaultQueue.Close()
+		defaultQueue = nil
+	}
+}
@@ -0,0 +1,36 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"litedrive/internal/cache/rabbitmq"
+)
+
+// RabbitQueue 基于 RabbitMQ 的任务队列, 重试及死信由 rabbitmq 包实现
+type RabbitQueue struct{}
+
+var _ Queue = (*RabbitQueue)(nil)
+
+// NewRabbitQueue 创建 RabbitMQ 任务队列, 连接在首次使用时建立, 连接失败不影响进程启动
+func NewRabbitQueue() *RabbitQueue {
+	return &RabbitQueue{}
+}
+
+func (q *RabbitQueue) Publish(ctx context.Context, topic string, payload []byte) error {
+	if err := rabbitmq.PublishTopic(topic, payload); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return nil
+}
+
+func (q *RabbitQueue) Consume(ctx context.Context, topic string, handler Handler, onDeadLetter DeadLetterHandler) error {
+	return rabbitmq.StartConsume(ctx, topic, "litedrive_"+topic, handler, onDeadLetter)
+}
+
+func (q *RabbitQueue) InProcess() bool {
+	return false
+}
+
+func (q *RabbitQueue) Close() {
+	rabbitmq.Close()
+}
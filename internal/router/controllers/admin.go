@@ -0,0 +1,21 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"litedrive/internal/services/explorer"
+	"net/http"
+)
+
+// ScrubReport 获取完整性校验报告
+func ScrubReport(c *gin.Context) {
+	scrubService := explorer.ScrubService{}
+	res := scrubService.Report(c)
+	c.JSON(http.StatusOK, res)
+}
+
+// RunScrub 立即执行一轮完整性校验
+func RunScrub(c *gin.Context) {
+	scrubService := explorer.ScrubService{}
+	res := scrubService.Run(c)
+	c.JSON(http.StatusOK, res)
+}
@@ -22,9 +22,11 @@ func GetFileInfo(c *gin.Context) {
 
 func DownloadFile(c *gin.Context) {
 	fileService := explorer.FileService{}
-	_ = fileService.DownloadFile(c)
-	// 多次响应会报错响应内
-	//c.JSON(http.StatusOK, res)
+	res := fileService.DownloadFile(c)
+	// 文件内容已写出时不能再返回 JSON, 只有出错时才返回
+	if !c.Writer.Written() {
+		c.JSON(http.StatusOK, res)
+	}
 }
 
 func DeleteFile(c *gin.Context) {
@@ -39,6 +41,20 @@ func ListFiles(c *gin.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
+func ListPendingTransfers(c *gin.Context) {
+	fileService := explorer.FileService{}
+	res := fileService.ListPendingTransfers(c)
+	c.JSON(http.StatusOK, res)
+}
+
+func SignedDownload(c *gin.Context) {
+	fileService := explorer.FileService{}
+	res := fileService.SignedDownload(c)
+	if !c.Writer.Written() {
+		c.JSON(http.StatusOK, res)
+	}
+}
+
 func DownloadURL(c *gin.Context) {
 	fileService := explorer.FileService{}
 	res := fileService.DownloadURL(c)
@@ -56,6 +72,17 @@ func RapidCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
+func RapidVerify(c *gin.Context) {
+	var service explorer.RapidVerifyService
+	if err := c.ShouldBindJSON(&service); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ErrorResponse(err))
+		return
+	}
+	res := service.RapidVerify(c)
+
+	respond(c, res)
+}
+
 func RenameFile(c *gin.Context) {
 	var service explorer.RenameFileService
 	if err := c.ShouldBindJSON(&service); err != nil {
@@ -65,3 +92,33 @@ func RenameFile(c *gin.Context) {
 	res := service.RenameFile(c)
 	c.JSON(http.StatusOK, res)
 }
+
+func CopyFile(c *gin.Context) {
+	var service explorer.CopyFileService
+	if err := c.ShouldBindJSON(&service); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ErrorResponse(err))
+		return
+	}
+	res := service.CopyFile(c)
+	c.JSON(http.StatusOK, res)
+}
+
+func InitDirectUpload(c *gin.Context) {
+	var service explorer.DirectUploadService
+	if err := c.ShouldBindJSON(&service); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ErrorResponse(err))
+		return
+	}
+	res := service.InitDirectUpload(c)
+	c.JSON(http.StatusOK, res)
+}
+
+func CompleteDirectUpload(c *gin.Context) {
+	var service explorer.DirectCompleteService
+	if err := c.ShouldBindJSON(&service); err != nil {
+		c.JSON(http.StatusBadRequest, serializer.ErrorResponse(err))
+		return
+	}
+	res := service.CompleteDirectUpload(c)
+	respond(c, res)
+}
@@ -3,9 +3,19 @@ package controllers
 import (
 	"github.com/gin-gonic/gin"
 	"litedrive/internal/services/explorer"
+	"litedrive/pkg/serializer"
 	"net/http"
 )
 
+// respond 无权操作时返回 403, 其余情况与其他接口一致返回 200
+func respond(c *gin.Context, res serializer.Response) {
+	if res.Code == http.StatusForbidden {
+		c.JSON(http.StatusForbidden, res)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
 // InitializeMultipartUpload 初始化分块上传
 func InitializeMultipartUpload(c *gin.Context) {
 	res := explorer.InitalMultipartUpload(c)
@@ -15,11 +25,23 @@ func InitializeMultipartUpload(c *gin.Context) {
 // UploadPart 处理分块上传
 func UploadPart(c *gin.Context) {
 	res := explorer.UploadPart(c)
-	c.JSON(http.StatusOK, res)
+	respond(c, res)
 }
 
 // CompleteMultipartUpload 处理分块合并
 func CompleteMultipartUpload(c *gin.Context) {
 	res := explorer.CompleteMultipartUpload(c)
-	c.JSON(http.StatusOK, res)
+	respond(c, res)
+}
+
+// MultipartUploadStatus 查询分块上传进度
+func MultipartUploadStatus(c *gin.Context) {
+	res := explorer.GetMultipartUploadStatus(c)
+	respond(c, res)
+}
+
+// AbortMultipartUpload 取消分块上传
+func AbortMultipartUpload(c *gin.Context) {
+	res := explorer.AbortMultipartUpload(c)
+	respond(c, res)
 }
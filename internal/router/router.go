@@ -13,10 +13,11 @@ func InitRouter() *gin.Engine {
 
 	// 配置 CORS 中间件,默认是放行所有跨域请求
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},                                                // 允许的源
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}, // 允许的方法
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},          // 允许的头部
-		AllowCredentials: true,                                                         // 如果需要发送 cookies
+		AllowOrigins:     []string{"*"},                                                                                                  // 允许的源
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},                                                   // 允许的方法
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Range", "If-Range", "If-None-Match", "If-Modified-Since"}, // 允许的头部
+		ExposeHeaders:    []string{"Content-Length", "Content-Range", "Content-Disposition", "Accept-Ranges", "ETag"},                    // 下载时前端可读取的头部
+		AllowCredentials: true,                                                                                                           // 如果需要发送 cookies
 		MaxAge:           12 * time.Hour,
 	}))
 
@@ -26,17 +27,25 @@ func InitRouter() *gin.Engine {
 		api.POST("/login", controllers.Login)
 	}
 
+	// 签名下载链接自带凭证, 不经过 JWT 校验
+	r.GET("/api/files/signed/:userFileID", controllers.SignedDownload)
+
 	apiFiles := r.Group("/api/files")
 	{
 		apiFiles.Use(middlewares.JwtAuthMiddleware())
-		apiFiles.POST("/upload", controllers.UploadFile)            // 上传文件
-		apiFiles.GET("/:fileID", controllers.GetFileInfo)           // 获取文件信息
-		apiFiles.GET("/download/:fileID", controllers.DownloadFile) // 下载文件
-		apiFiles.DELETE("/:fileID", controllers.DeleteFile)         // 删除文件
-		apiFiles.PUT("/", controllers.RenameFile)                   // 文件重命名
-		apiFiles.GET("/list", controllers.ListFiles)                // 获取用户文件列表
-		apiFiles.GET("/downloadurl", controllers.DownloadURL)       // 获取下载链接
-		apiFiles.POST("/rapidcheck", controllers.RapidCheck)        // 秒传接口
+		apiFiles.POST("/upload", controllers.UploadFile)                    // 上传文件
+		apiFiles.GET("/:userFileID", controllers.GetFileInfo)               // 获取文件信息
+		apiFiles.GET("/download/:userFileID", controllers.DownloadFile)     // 下载文件
+		apiFiles.DELETE("/:userFileID", controllers.DeleteFile)             // 删除文件
+		apiFiles.PUT("/", controllers.RenameFile)                           // 文件重命名
+		apiFiles.POST("/copy", controllers.CopyFile)                        // 复制文件
+		apiFiles.GET("/list", controllers.ListFiles)                        // 获取用户文件列表
+		apiFiles.GET("/transfers", controllers.ListPendingTransfers)        // 获取未完成异步转移的文件
+		apiFiles.GET("/downloadurl/:userFileID", controllers.DownloadURL)   // 获取下载链接
+		apiFiles.POST("/rapidcheck", controllers.RapidCheck)                // 秒传接口: 获取挑战
+		apiFiles.POST("/rapidcheck/verify", controllers.RapidVerify)        // 秒传接口: 校验挑战应答
+		apiFiles.POST("/direct/init", controllers.InitDirectUpload)         // 直传: 获取上传链接
+		apiFiles.POST("/direct/complete", controllers.CompleteDirectUpload) // 直传: 确认上传完成
 	}
 
 	apiDir := r.Group("/api/dir")
@@ -55,6 +64,15 @@ func InitRouter() *gin.Engine {
 		apiChunk.POST("/initMultUpload", controllers.InitializeMultipartUpload)
 		apiChunk.POST("/uploadPart", controllers.UploadPart)
 		apiChunk.POST("/completeMultUpload", controllers.CompleteMultipartUpload)
+		apiChunk.GET("/status/:uploadID", controllers.MultipartUploadStatus)
+		apiChunk.POST("/abortMultUpload", controllers.AbortMultipartUpload)
+	}
+
+	apiAdmin := r.Group("/api/admin")
+	{
+		apiAdmin.Use(middlewares.JwtAuthMiddleware(), middlewares.AdminMiddleware())
+		apiAdmin.GET("/scrub", controllers.ScrubReport) // 完整性校验报告
+		apiAdmin.POST("/scrub", controllers.RunScrub)   // 立即执行一轮完整性校验
 	}
 
 	return r
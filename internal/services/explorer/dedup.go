@@ -0,0 +1,82 @@
+package explorer
+
+import (
+	"context"
+	"litedrive/internal/firesystem"
+	"litedrive/internal/firesystem/blob"
+	"litedrive/internal/models"
+	"litedrive/internal/queue"
+	"log"
+)
+
+// 文件实体按 SHA-256 去重: 内容已存在时不再保存新的副本, 只为用户新增一条 UserFile 引用
+// 文件实体的引用即 UserFile 记录, 删除最后一个引用时才删除实体及其副本
+
+// saveUploadedFile 保存上传完成的文件并关联到用户, outbox 为文件创建后需要执行的后台任务
+// 失败时不删除 record 中的副本, 直传及分块上传保留会话供客户端重试, 由调用方决定是否清理
+// record 为本次上传写入的文件实体, 相同内容已存在时关联已有实体, 并删除本次多写入的副本, created 为 false
+func saveUploadedFile(ctx context.Context, record *models.File, userFile *models.UserFile, outbox ...models.OutboxMessage) (file *models.File, created bool, err error) {
+	file, created, err = models.CreateFileWithUserFile(record, userFile, outbox...)
+	if err != nil {
+		return nil, false, err
+	}
+	if !created {
+		discardRedundantReplicas(ctx, record.Replicas, file)
+	} else if len(outbox) > 0 {
+		queue.NotifyOutbox()
+	}
+	return file, created, nil
+}
+
+// discardRedundantReplicas 删除不属于已有文件实体的副本
+func discardRedundantReplicas(ctx context.Context, replicas []models.FileReplica, file *models.File) {
+	if len(replicas) == 0 {
+		return
+	}
+	existing, err := blob.Replicas(file)
+	if err != nil {
+		log.Printf("读取文件 %d 的副本失败, 保留本次写入的副本: %v", file.ID, err)
+		return
+	}
+	owned := make(map[string]bool, len(existing))
+	for _, r := range existing {
+		owned[r.Backend+":"+r.Path] = true
+	}
+
+	for _, r := range replicas {
+		if owned[r.Backend+":"+r.Path] {
+			continue
+		}
+		driver, err := firesystem.DriverFor(r.Backend)
+		if err != nil {
+			log.Printf("删除重复副本 %s:%s 失败: %v", r.Backend, r.Path, err)
+			continue
+		}
+		if err := driver.Delete(ctx, r.Path); err != nil {
+			log.Printf("删除重复副本 %s:%s 失败: %v", r.Backend, r.Path, err)
+		}
+	}
+}
+
+// discardUnreferencedReplicas 写入文件记录失败后删除已提交的副本
+// 以内容哈希为 key 的对象可能已被并发上传的相同文件引用, 这些对象保留
+func discardUnreferencedReplicas(replicas []models.FileReplica) {
+	for _, r := range replicas {
+		referenced, err := models.ReferencedObjectKeys(r.Backend, []string{r.Path})
+		if err != nil {
+			log.Printf("查询副本 %s:%s 的引用失败, 保留该对象: %v", r.Backend, r.Path, err)
+			continue
+		}
+		if referenced[r.Path] {
+			continue
+		}
+		driver, err := firesystem.DriverFor(r.Backend)
+		if err != nil {
+			log.Printf("删除副本 %s:%s 失败: %v", r.Backend, r.Path, err)
+			continue
+		}
+		if err := driver.Delete(context.Background(), r.Path); err != nil {
+			log.Printf("删除副本 %s:%s 失败: %v", r.Backend, r.Path, err)
+		}
+	}
+}
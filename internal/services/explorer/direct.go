@@ -0,0 +1,320 @@
+package explorer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	goredis "github.com/redis/go-redis/v9"
+	"litedrive/internal/cache/redis"
+	"litedrive/internal/firesystem"
+	"litedrive/internal/firesystem/blob"
+	"litedrive/internal/models"
+	"litedrive/internal/utils"
+	"litedrive/pkg/serializer"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 浏览器直传: 服务端只签发上传链接, 文件内容由客户端直接写入 Ceph / COS 桶,
+// 上传完成后调用确认接口, 由服务端校验对象大小及哈希后写入文件记录
+
+const (
+	defaultDirectPartSize = 64 * 1024 * 1024
+	// S3 协议单次上传最多 10000 个分块
+	maxDirectParts = 10000
+)
+
+// DirectUploadService 直传初始化参数
+type DirectUploadService struct {
+	FileName string `json:"fileName" binding:"required"`
+	FileHash string `json:"fileHash" binding:"required"`
+	FileSize int64  `json:"fileSize"`
+	DirID    uint   `json:"dirId"`
+}
+
+// DirectUploadInfo 直传上传链接, 文件不超过 PartSize 时只返回 URL, 否则返回每个分块的上传链接
+type DirectUploadInfo struct {
+	UploadID  string    `json:"uploadId"`
+	Backend   string    `json:"backend"`
+	URL       string    `json:"url,omitempty"`
+	PartSize  int64     `json:"partSize,omitempty"`
+	PartURLs  []string  `json:"partUrls,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// DirectCompleteService 直传确认参数, 分块直传时需要带上每个分块的 ETag
+type DirectCompleteService struct {
+	UploadID string            `json:"uploadId" binding:"required"`
+	Parts    []firesystem.Part `json:"parts"`
+}
+
+var (
+	// errObjectMissing 客户端尚未完成上传
+	errObjectMissing = errors.New("文件尚未上传")
+	// errContentMismatch 上传内容的大小或哈希与声明的不一致
+	errContentMismatch = blob.ErrContentMismatch
+)
+
+// duSessionsKey 直传会话的过期时间, 过期后由清理任务取消远端未合并的 multipart upload 并删除未确认的对象
+const duSessionsKey = "DU_SESSIONS"
+
+func directKey(uploadID string) string {
+	return "DU_" + uploadID
+}
+
+// newUploadID 生成不可预测的上传 ID
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// isValidSha 校验 SHA-256 的十六进制格式
+func isValidSha(sha string) bool {
+	if len(sha) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(sha)
+	return err == nil
+}
+
+// InitDirectUpload 签发直传链接
+func (s *DirectUploadService) InitDirectUpload(c *gin.Context) serializer.Response {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return serializer.ErrorResponse(errors.New("user not logged in"))
+	}
+	userIDInt := userID.(uint)
+
+	// 统一为小写, 与服务端计算的摘要及秒传索引一致
+	s.FileHash = strings.ToLower(s.FileHash)
+	if !isValidSha(s.FileHash) {
+		return serializer.ErrorResponse(errors.New("无效的文件哈希"))
+	}
+	if err := validateFileName(s.FileName); err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	if s.FileSize <= 0 {
+		return serializer.ErrorResponse(errors.New("无效的文件大小"))
+	}
+
+	existingFile, _, err := rapidCheckCandidate(userIDInt, s.FileHash)
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	if existingFile != nil {
+		return serializer.ErrorResponse(errors.New("文件已存在，请使用秒传"))
+	}
+
+	// 直传只支持写入单个对象存储后端
+	targets, err := blob.Placement(s.FileSize, userIDInt)
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	if len(targets) != 1 {
+		return serializer.ErrorResponse(errors.New("多副本存储模式不支持直传"))
+	}
+	driver, err := firesystem.GetDriver(targets[0])
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	presigner, ok := driver.(firesystem.UploadPresigner)
+	if !ok {
+		return serializer.ErrorResponse(errors.New("当前存储后端不支持直传"))
+	}
+
+	config, err := utils.LoadConfig()
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	ttl := time.Duration(config.Storage.UploadURLTTL) * time.Second
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	partSize := config.Storage.DirectPartSize
+	if partSize <= 0 {
+		partSize = defaultDirectPartSize
+	}
+	if minSize := (s.FileSize + maxDirectParts - 1) / maxDirectParts; partSize < minSize {
+		partSize = minSize
+	}
+
+	uploadID, err := newUploadID()
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	// 直传对象不使用内容哈希作为 key, 避免未校验的内容覆盖已有文件
+	objectKey, err := firesystem.ObjectKey(targets[0], s.FileHash+"."+uploadID)
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+
+	info := DirectUploadInfo{
+		UploadID:  uploadID,
+		Backend:   targets[0].String(),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	multipartID := ""
+	if s.FileSize <= partSize {
+		info.URL, err = presigner.PresignPut(c.Request.Context(), objectKey, s.FileSize, ttl)
+		if err != nil {
+			return serializer.ErrorResponse(err)
+		}
+	} else {
+		mp, ok := driver.(firesystem.MultipartDriver)
+		if !ok {
+			return serializer.ErrorResponse(errors.New("当前存储后端不支持分块直传"))
+		}
+		multipartID, err = mp.CreateMultipart(c.Request.Context(), objectKey)
+		if err != nil {
+			return serializer.ErrorResponse(err)
+		}
+		partCount := int((s.FileSize + partSize - 1) / partSize)
+		info.PartSize = partSize
+		info.PartURLs = make([]string, 0, partCount)
+		for i := 1; i <= partCount; i++ {
+			u, err := mp.PresignPart(c.Request.Context(), objectKey, multipartID, i, ttl)
+			if err != nil {
+				mp.AbortMultipart(context.Background(), objectKey, multipartID)
+				return serializer.ErrorResponse(err)
+			}
+			info.PartURLs = append(info.PartURLs, u)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(redis.Ctx, 3*time.Second)
+	defer cancel()
+
+	// 链接过期后再保留一段时间, 给客户端留出确认的时间
+	expiresAt := time.Now().Add(ttl + time.Hour)
+	key := directKey(uploadID)
+	pipe := redis.RedisCli.TxPipeline()
+	pipe.HSet(ctx, key,
+		"user_id", userIDInt,
+		"filehash", s.FileHash,
+		"filesize", s.FileSize,
+		"filename", s.FileName,
+		"dir_id", s.DirID,
+		"backend", info.Backend,
+		"object_key", objectKey,
+		"multipart_id", multipartID,
+		"part_count", len(info.PartURLs),
+	)
+	// 会话在过期后再保留 sessionGCGrace, 让清理任务还能读到对象及 multipart upload 的信息
+	// 客户端上传后未确认的对象由清理任务删除, 分块直传同时取消 multipart upload
+	pipe.ExpireAt(ctx, key, expiresAt.Add(sessionGCGrace))
+	pipe.ZAdd(ctx, duSessionsKey, goredis.Z{Score: float64(expiresAt.Unix()), Member: uploadID})
+	if _, err = pipe.Exec(ctx); err != nil {
+		if multipartID != "" {
+			mp := driver.(firesystem.MultipartDriver)
+			mp.AbortMultipart(context.Background(), objectKey, multipartID)
+		}
+		log.Printf("Redis 写入直传信息失败: %v", err)
+		return serializer.ErrorResponse(errors.New("Redis 写入失败"))
+	}
+
+	return serializer.SuccessResponse(info)
+}
+
+// CompleteDirectUpload 确认直传完成, 校验对象后写入文件记录
+func (s *DirectCompleteService) CompleteDirectUpload(c *gin.Context) serializer.Response {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return serializer.ErrorResponse(errors.New("user not logged in"))
+	}
+	userIDInt := userID.(uint)
+
+	ctx, cancel := context.WithTimeout(redis.Ctx, 3*time.Second)
+	defer cancel()
+
+	key := directKey(s.UploadID)
+	session, err := redis.RedisCli.HGetAll(ctx, key).Result()
+	if err != nil {
+		return serializer.ErrorResponse(errors.New("Redis 读取失败"))
+	}
+	if len(session) == 0 {
+		return serializer.ErrorResponse(errors.New("上传任务不存在或已过期"))
+	}
+	if session["user_id"] != strconv.FormatUint(uint64(userIDInt), 10) {
+		return serializer.ForbiddenResponse(errUploadForbidden)
+	}
+
+	fileHash := session["filehash"]
+	fileSize, _ := strconv.ParseInt(session["filesize"], 10, 64)
+	dirID, _ := strconv.ParseUint(session["dir_id"], 10, 64)
+	objectKey := session["object_key"]
+	backend := session["backend"]
+
+	driver, err := firesystem.DriverFor(backend)
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+
+	// 分块直传需要先合并分块
+	if multipartID := session["multipart_id"]; multipartID != "" {
+		partCount, _ := strconv.Atoi(session["part_count"])
+		if len(s.Parts) != partCount {
+			return serializer.ErrorResponse(fmt.Errorf("分块数量不匹配, 需要 %d 个分块", partCount))
+		}
+		mp, ok := driver.(firesystem.MultipartDriver)
+		if !ok {
+			return serializer.ErrorResponse(errors.New("当前存储后端不支持分块直传"))
+		}
+		if err := mp.CompleteMultipart(c.Request.Context(), objectKey, multipartID, s.Parts); err != nil {
+			return serializer.ErrorResponse(err)
+		}
+		// 分块已合并, 之后重试确认时直接校验对象, 清理任务只需删除对象
+		redis.RedisCli.HSet(redis.Ctx, key, "multipart_id", "")
+	}
+
+	// 校验对象大小及哈希, 不一致时删除对象
+	err = verifyObject(c.Request.Context(), driver, objectKey, fileHash, fileSize)
+	if errors.Is(err, errContentMismatch) {
+		if delErr := driver.Delete(context.Background(), objectKey); delErr != nil {
+			log.Printf("删除校验失败的直传对象失败: %v", delErr)
+		}
+		redis.RedisCli.ZRem(redis.Ctx, duSessionsKey, s.UploadID)
+		redis.RedisCli.Del(redis.Ctx, key)
+		return serializer.ErrorResponse(err, "文件校验失败")
+	}
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+
+	// 相同内容已存在时关联已有文件, 并删除本次直传的对象
+	fileRecord, _, err := saveUploadedFile(c.Request.Context(), &models.File{
+		Sha:      fileHash,
+		Size:     fileSize,
+		Path:     objectKey,
+		Backend:  backend,
+		Replicas: []models.FileReplica{{Backend: backend, Path: objectKey, Status: "active"}},
+	}, &models.UserFile{
+		UserID:   userIDInt,
+		FileName: session["filename"],
+		DirID:    uint(dirID),
+	})
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+
+	// 文件记录写入后对象由文件实体引用, 不再需要清理任务处理
+	redis.RedisCli.ZRem(redis.Ctx, duSessionsKey, s.UploadID)
+	redis.RedisCli.Del(redis.Ctx, key)
+	return serializer.SuccessResponse(fileRecord)
+}
+
+// verifyObject 校验存储后端中对象的大小及 SHA-256
+func verifyObject(ctx context.Context, driver firesystem.Driver, objectKey, fileHash string, fileSize int64) error {
+	err := blob.Verify(ctx, driver, objectKey, fileHash, fileSize)
+	if errors.Is(err, firesystem.ErrNotExist) {
+		return errObjectMissing
+	}
+	return err
+}
@@ -0,0 +1,174 @@
+package explorer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"litedrive/internal/firesystem"
+	"litedrive/internal/firesystem/blob"
+	"litedrive/internal/models"
+	"litedrive/internal/utils"
+	"litedrive/pkg/serializer"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DownloadFile 从文件所在的存储后端流式返回文件内容, 不在内存中缓存整个对象
+// 以 File.Sha 作为强 ETag, 支持 Range / If-Range / If-None-Match / If-Modified-Since
+func (s *FileService) DownloadFile(c *gin.Context) serializer.Response {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return serializer.ErrorResponse(errors.New("用户未登录"))
+	}
+	userIDInt := userID.(uint)
+
+	userFile, err := ownedUserFile(c, userIDInt)
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+
+	return serveUserFile(c, userFile)
+}
+
+// ownedUserFile 按路径中的 UserFile.ID 查询当前用户的文件, 同一文件的多个副本(如复制产生的)各自独立
+func ownedUserFile(c *gin.Context, userID uint) (*models.UserFile, error) {
+	userFileID, err := strconv.ParseUint(c.Param("userFileID"), 10, 64)
+	if err != nil {
+		return nil, errors.New("无效的文件ID")
+	}
+	var userFile models.UserFile
+	if err := models.DB.First(&userFile, "id = ? AND user_id = ?", uint(userFileID), userID).Error; err != nil {
+		return nil, errors.New("文件不存在或无权限")
+	}
+	return &userFile, nil
+}
+
+// SignedDownload 通过 API 签名的链接下载文件, 链接本身即凭证, 无需登录
+func (s *FileService) SignedDownload(c *gin.Context) serializer.Response {
+	userFileID, err := strconv.ParseUint(c.Param("userFileID"), 10, 64)
+	if err != nil {
+		return serializer.ErrorResponse(errors.New("无效的文件ID"))
+	}
+	if err := utils.VerifyDownload(uint(userFileID), c.Query("expires"), c.Query("sign")); err != nil {
+		return serializer.ErrorResponse(err)
+	}
+
+	var userFile models.UserFile
+	if err := models.DB.First(&userFile, "id = ?", uint(userFileID)).Error; err != nil {
+		return serializer.ErrorResponse(errors.New("文件不存在"))
+	}
+	return serveUserFile(c, &userFile)
+}
+
+// serveUserFile 将用户文件的内容写入响应
+func serveUserFile(c *gin.Context, userFile *models.UserFile) serializer.Response {
+	// 获取文件信息
+	var file models.File
+	if err := models.DB.First(&file, "id = ?", userFile.FileID).Error; err != nil {
+		return serializer.ErrorResponse(err)
+	}
+
+	// 检查文件是否存在, 主副本不可用时回退到其他副本
+	loc, err := blob.Locate(c.Request.Context(), &file)
+	if errors.Is(err, firesystem.ErrNotExist) {
+		return serializer.ErrorResponse(errors.New("文件已被删除或丢失"))
+	}
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+
+	// 只有真正需要读取内容时才会向存储后端发起 (Range) 请求
+	reader := firesystem.NewReadSeeker(c.Request.Context(), loc.Driver, loc.Key, loc.Info.Size)
+	defer reader.Close()
+
+	// 返回文件
+	header := c.Writer.Header()
+	header.Set("Content-Type", firesystem.ContentType(userFile.FileName))
+	header.Set("Content-Disposition", firesystem.ContentDisposition(userFile.FileName))
+	header.Set("ETag", `"`+file.Sha+`"`)
+	http.ServeContent(c.Writer, c.Request, userFile.FileName, file.CreatedAt, reader)
+	// 304 / 416 等无响应体的情况也要写出响应头, 避免控制器再写入 JSON
+	c.Writer.WriteHeaderNow()
+	return serializer.SuccessResponse(file)
+}
+
+// DownloadURLResponse 限时下载链接
+type DownloadURLResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// DownloadURL 为当前用户的文件生成限时下载链接
+// Ceph / COS 使用对象存储的预签名链接, 本地存储返回由 API 签名的链接
+func (s *FileService) DownloadURL(c *gin.Context) serializer.Response {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return serializer.ErrorResponse(errors.New("用户未登录"))
+	}
+	userIDInt := userID.(uint)
+
+	userFile, err := ownedUserFile(c, userIDInt)
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+
+	file, err := models.GetFileByID(strconv.FormatUint(uint64(userFile.FileID), 10))
+	if err != nil {
+		return serializer.ErrorResponse(err, "文件记录获取失败")
+	}
+
+	loc, err := blob.Locate(c.Request.Context(), file)
+	if errors.Is(err, firesystem.ErrNotExist) {
+		return serializer.ErrorResponse(errors.New("文件已被删除或丢失"))
+	}
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+
+	config, err := utils.LoadConfig()
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	ttl := time.Duration(config.Storage.DownloadURLTTL) * time.Second
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	expiresAt := time.Now().Add(ttl)
+
+	//生成下载链接
+	signedURL, err := loc.Driver.PresignGet(c.Request.Context(), loc.Key, ttl, userFile.FileName)
+	if errors.Is(err, firesystem.ErrNotSupported) {
+		signedURL, err = localDownloadURL(c, config, userFile.ID, expiresAt)
+	}
+	if err != nil {
+		return serializer.ErrorResponse(err, "生成下载链接失败")
+	}
+
+	return serializer.SuccessResponse(DownloadURLResponse{URL: signedURL, ExpiresAt: expiresAt})
+}
+
+// localDownloadURL 生成由 API 自身校验签名的下载链接
+func localDownloadURL(c *gin.Context, config *utils.Config, userFileID uint, expiresAt time.Time) (string, error) {
+	expires := expiresAt.Unix()
+	sign, err := utils.SignDownload(userFileID, expires)
+	if err != nil {
+		return "", err
+	}
+
+	base := strings.TrimRight(config.Server.PublicURL, "/")
+	if base == "" {
+		scheme := "http"
+		if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		base = scheme + "://" + c.Request.Host
+	}
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("sign", sign)
+	return fmt.Sprintf("%s/api/files/signed/%d?%s", base, userFileID, query.Encode()), nil
+}
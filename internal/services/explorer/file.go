@@ -1,33 +1,31 @@
 package explorer
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
-	"io"
 	rbmq "litedrive/internal/cache/rabbitmq"
-	"litedrive/internal/firesystem/ceph"
-	"litedrive/internal/firesystem/cos"
+	"litedrive/internal/firesystem"
+	"litedrive/internal/firesystem/blob"
 	"litedrive/internal/models"
-	"litedrive/internal/utils"
+	"litedrive/internal/services/transfer"
 	cmn "litedrive/pkg/common"
 	"litedrive/pkg/serializer"
-	"log"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 type FileService struct{}
 
-type RapidCheckService struct {
-	FileName string `json:"fileName"`
-	FileHash string `json:"fileHash"`
-	DirID    uint   `json:"dirId"`
+// CopyFileService 复制用户文件, 只新增引用, 不复制文件内容
+type CopyFileService struct {
+	ID          uint   `json:"id"`          // 源 UserFile.ID
+	DirID       uint   `json:"dirId"`       // 目标目录, 0 为根目录
+	NewFileName string `json:"newFileName"` // 新的文件名, 为空时沿用原文件名
 }
 
 type RenameFileService struct {
@@ -36,12 +34,6 @@ type RenameFileService struct {
 }
 
 func (s *FileService) UploadFile(c *gin.Context) serializer.Response {
-	// 读取配置文件
-	config, err := utils.LoadConfig()
-	if err != nil {
-		return serializer.ErrorResponse(err)
-	}
-
 	// 获取上下文中的 user_id
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -66,180 +58,103 @@ func (s *FileService) UploadFile(c *gin.Context) serializer.Response {
 		return serializer.ErrorResponse(err)
 	}
 	defer file.Close()
-
-	//计算文件 SHA-256 哈希值
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
+	if err := validateFileName(header.Filename); err != nil {
 		return serializer.ErrorResponse(err)
 	}
-	fileSha := hex.EncodeToString(hash.Sum(nil))
 
-	//重置文件指针
-	_, err = file.Seek(0, io.SeekStart)
-	if err != nil {
-		return serializer.ErrorResponse(err)
+	fileSize := header.Size
+	userFileRecord := &models.UserFile{
+		UserID:   userIDInt,
+		FileName: header.Filename,
+		DirID:    dirID,
 	}
 
-	// 生成存储路径
-	filePath := filepath.Join(config.Storage.Root, header.Filename)
-
-	// 创建文件
-	outFile, err := os.Create(filePath)
+	targets, err := blob.Placement(fileSize, userIDInt)
 	if err != nil {
 		return serializer.ErrorResponse(err)
 	}
-
-	//写入文件
-	fileSize, err := io.Copy(outFile, file)
-	if err != nil {
-		return serializer.ErrorResponse(err)
+	// 异步转移时先写入本地存储, 由转移服务写入 COS
+	asyncTransfer := len(targets) == 1 && targets[0] == cmn.StoreCOS && rbmq.AsyncTransferEnabled()
+	if asyncTransfer {
+		targets = []cmn.StoreType{cmn.StoreLocal}
 	}
 
-	//defer outFile.Close()
-	err = outFile.Close()
+	// 读取文件一次, 同时计算 SHA-256 并写入存储后端的临时位置
+	staged, err := blob.Stage(c.Request.Context(), targets, file, fileSize)
 	if err != nil {
-		log.Printf("关闭 outFile 失败: %v", err)
 		return serializer.ErrorResponse(err)
 	}
+	fileSha := staged.Sha
 
-	//重置文件指针
-	_, err = file.Seek(0, io.SeekStart)
+	// 相同内容已存在时丢弃本次写入, 直接关联已有文件
+	existingFile, err := models.GetFileBySha(fileSha)
 	if err != nil {
+		staged.Discard(c.Request.Context())
 		return serializer.ErrorResponse(err)
 	}
-
-	// TODO ceph 与 cos 异步逻辑控制
-	storeType := cmn.ParseStoreType(config.Storage.CurrentStoreType)
-	// 声明并初始化 finalFilePath
-	finalFilePath := filePath
-
-	switch storeType {
-	case cmn.StoreCeph:
-		// 文件写入Ceph存储
-		cephPath := config.Storage.CephRootDir + fileSha
-		err = ceph.UploadObject(cephPath, file)
-		//fileMeta.Location = cephPath
-		if err != nil {
+	if existingFile != nil {
+		staged.Discard(c.Request.Context())
+		userFileRecord.FileID = existingFile.ID
+		if err := userFileRecord.AttachUserFile(); err != nil {
 			return serializer.ErrorResponse(err)
 		}
-		finalFilePath = cephPath
-
-	case cmn.StoreCOS:
-		// 文件写入COS存储
-		cosPath := config.Storage.CosRootDir + fileSha
-		// 判断写入COS为同步还是异步
-		if !rbmq.AsyncTransfeEnable {
-			// TODO: 设置oss中的文件名，方便指定文件名下载
-			err = cos.UploadFile(cosPath, file)
-			if err != nil {
-				return serializer.ErrorResponse(err)
-			}
-			finalFilePath = cosPath
-
-		} else {
-			// 异步上传任务推送到 RabbitMQ
-			data := rbmq.TransferData{
-				FileHash:      fileSha,
-				CurLocation:   filePath,
-				DestLocation:  cosPath,
-				DestStoreType: cmn.StoreCOS,
-			}
-			pubData, _ := json.Marshal(data)
-			pubSuc := rbmq.Publish(
-				rbmq.TransExchangeName,
-				rbmq.TransOSSRoutingKey,
-				pubData,
-			)
-			if !pubSuc {
-				log.Println("异步任务推送失败，稍后可重试")
-				// TODO: 当前发送转移信息失败，稍后重试
-			}
-
-		}
-		//default:
-		//	// 其他类型暂未支持
-		//	return serializer.ErrorResponse(errors.New("暂不支持的存储类型"))
+		return serializer.SuccessResponse(existingFile)
 	}
 
-	// 上传完成后删除本地临时文件
-	if storeType == cmn.StoreCeph || storeType == cmn.StoreCOS {
-		// 尝试删除本地文件
-		err = os.Remove(filePath)
+	// 异步转移完成前保留本地副本, 下载由本地副本提供
+	// 转移任务与文件记录在同一事务中写入发件箱, 由后台任务投递, 队列不可用时重试投递
+	// 转移任务在提交对象之前生成, 生成失败时只需丢弃临时位置的内容
+	var outbox []models.OutboxMessage
+	if asyncTransfer {
+		msg, err := newTransferMessage(fileSha)
 		if err != nil {
-			log.Printf("删除本地临时文件失败: %v", err)
-			// 可以考虑添加延迟或重试机制
+			staged.Discard(c.Request.Context())
+			return serializer.ErrorResponse(err)
 		}
+		outbox = append(outbox, msg)
 	}
 
-	//创建文件记录
-	fileRecord := &models.File{
-		Sha:  fileSha,
-		Size: fileSize,
-		Path: finalFilePath,
-	}
-
-	//调用 Model 层方法存入文件表
-	if err := fileRecord.CreateFile(); err != nil {
+	// 以内容哈希确定对象的最终位置
+	replicas, err := staged.Commit(c.Request.Context())
+	if err != nil {
 		return serializer.ErrorResponse(err)
 	}
 
-	//绑定用户文件
-	userFileRecord := &models.UserFile{
-		UserID:   userIDInt,
-		FileID:   fileRecord.ID,
-		FileName: header.Filename,
-		DirID:    dirID,
+	//创建文件记录并绑定用户文件, 并发上传相同内容时关联先写入的文件
+	record := &models.File{
+		Sha:      fileSha,
+		Size:     fileSize,
+		Path:     replicas[0].Path,
+		Backend:  replicas[0].Backend,
+		Replicas: replicas,
 	}
-
-	if err := userFileRecord.OnUserFileUploadFinished(); err != nil {
+	if asyncTransfer {
+		record.Status = models.FileStatusStaged
+	}
+	fileRecord, _, err := saveUploadedFile(c.Request.Context(), record, userFileRecord, outbox...)
+	if err != nil {
+		// 普通上传没有可以重试的会话, 删除已提交且没有被其他文件引用的对象
+		discardUnreferencedReplicas(replicas)
 		return serializer.ErrorResponse(err)
 	}
 
 	return serializer.SuccessResponse(fileRecord)
 }
 
+// GetFileInfo 按 UserFile.ID 获取当前用户的文件信息, 与下载、删除等接口使用相同的 ID
 func (s *FileService) GetFileInfo(c *gin.Context) serializer.Response {
-	fileID := c.Param("fileID")
-	file, err := models.GetFileByID(fileID)
-	if err != nil {
-		return serializer.ErrorResponse(err, "文件记录获取失败")
-	}
-	return serializer.SuccessResponse(file)
-}
-
-func (s *FileService) DownloadFile(c *gin.Context) serializer.Response {
 	userID, exists := c.Get("user_id")
 	if !exists {
 		return serializer.ErrorResponse(errors.New("用户未登录"))
 	}
-	userIDInt := userID.(uint)
-
-	fileIDStr := c.Param("fileID")
-	fileIDUint, err := strconv.ParseUint(fileIDStr, 10, 64)
+	userFile, err := ownedUserFile(c, userID.(uint))
 	if err != nil {
-		return serializer.ErrorResponse(errors.New("无效的文件ID"))
-	}
-
-	// 权限校验
-	var userFile models.UserFile
-	if err := models.DB.Where("user_id = ? AND file_id = ?", userIDInt, uint(fileIDUint)).First(&userFile).Error; err != nil {
-		return serializer.ErrorResponse(errors.New("文件不存在或无权限"))
-	}
-
-	// 获取文件信息
-	var file models.File
-	if err := models.DB.First(&file, "id = ?", userFile.FileID).Error; err != nil {
 		return serializer.ErrorResponse(err)
 	}
-
-	// 检查文件是否存在
-	if _, err := os.Stat(file.Path); os.IsNotExist(err) {
-		return serializer.ErrorResponse(errors.New("文件已被删除或丢失"))
+	if err := models.DB.First(&userFile.File, userFile.FileID).Error; err != nil {
+		return serializer.ErrorResponse(err, "文件记录获取失败")
 	}
-
-	// 返回文件
-	c.FileAttachment(file.Path, userFile.FileName)
-	return serializer.SuccessResponse(file)
+	return serializer.SuccessResponse(userFile)
 }
 
 func (s *FileService) DeleteFile(c *gin.Context) serializer.Response {
@@ -250,56 +165,18 @@ func (s *FileService) DeleteFile(c *gin.Context) serializer.Response {
 	}
 	userIDInt := userID.(uint)
 
-	// 获取 fileID
-	fileID := c.Param("fileID")
-
-	// 查询 UserFile 确保用户有权限删除该文件
-	var userFile models.UserFile
-	if err := models.DB.Where("user_id = ? AND file_id = ?", userIDInt, fileID).First(&userFile).Error; err != nil {
-		return serializer.ErrorResponse(errors.New("文件不存在或无权限"))
-	}
-
-	// 删除 UserFile 记录
-	if err := models.DB.Unscoped().Delete(&userFile).Error; err != nil {
-		return serializer.ErrorResponse(err)
-	}
-
-	// 检查该文件是否还有其他用户在使用
-	var count int64
-	if err := models.DB.Model(&models.UserFile{}).Where("file_id = ?", userFile.FileID).Count(&count).Error; err != nil {
+	// 按 UserFile.ID 查询, 确保用户有权限删除该文件
+	userFile, err := ownedUserFile(c, userIDInt)
+	if err != nil {
 		return serializer.ErrorResponse(err)
 	}
 
-	// 如果没有其他用户使用该文件，则物理删除文件
-	if count == 0 {
-		var file models.File
-		if err := models.DB.First(&file, userFile.FileID).Error; err != nil {
-			return serializer.ErrorResponse(err)
-		}
-
-		// TODO 做多存储删除策略
-		switch {
-		case strings.HasPrefix(file.Path, "storage"):
-			// 删除文件物理文件
-			if err := os.Remove(file.Path); err != nil {
-				return serializer.ErrorResponse(err)
-			}
-		case strings.HasPrefix(file.Path, "/ceph"):
-			if err := ceph.DeleteObject(file.Path); err != nil {
-				return serializer.ErrorResponse(err)
-			}
-		case strings.HasPrefix(file.Path, "cos"):
-			if err := cos.DeleteFile(file.Path); err != nil {
-				return serializer.ErrorResponse(err)
-			}
-		default:
-			return serializer.ErrorResponse(errors.New("未知的存储类型"))
-		}
-
-		// 删除 File 记录
-		if err := models.DB.Unscoped().Delete(&file).Error; err != nil {
-			return serializer.ErrorResponse(err)
+	// 删除 UserFile 记录并减少引用数, 没有引用的文件由后台回收任务删除
+	if err := userFile.DetachUserFile(); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return serializer.ErrorResponse(errors.New("文件不存在或无权限"))
 		}
+		return serializer.ErrorResponse(err)
 	}
 
 	return serializer.SuccessResponse(nil, "文件删除成功")
@@ -323,58 +200,42 @@ func (s *FileService) ListFiles(c *gin.Context) serializer.Response {
 	return serializer.SuccessResponse(allFiles)
 }
 
-// RapidCheck:秒传逻辑接口
-func (s *RapidCheckService) RapidCheck(c *gin.Context) serializer.Response {
-	// 获取上下文中的 user_id
+// TransferStatus 尚未完成转移的用户文件
+type TransferStatus struct {
+	ID        uint      `json:"id"` // UserFile.ID
+	FileName  string    `json:"fileName"`
+	FileHash  string    `json:"fileHash"`
+	Size      int64     `json:"size"`
+	Backend   string    `json:"backend"` // 当前提供下载的后端
+	Status    string    `json:"status"`  // staged / transferring / failed
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ListPendingTransfers 获取用户尚未完成异步转移的文件
+func (s *FileService) ListPendingTransfers(c *gin.Context) serializer.Response {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		return serializer.ErrorResponse(errors.New("user not logged in"))
+		return serializer.ErrorResponse(errors.New("user id not found"))
 	}
 	userIDInt := userID.(uint)
 
-	// 从前端请求获取文件 SHA256 和 文件名
-	fileSha := s.FileHash
-	fileName := s.FileName
-	if fileSha == "" {
-		return serializer.ErrorResponse(errors.New("file SHA256 is required"))
-	}
-	if fileName == "" {
-		return serializer.ErrorResponse(errors.New("file name is required"))
-	}
-
-	// 查找数据库，看该哈希值是否存在
-	existingFile, err := models.GetFileBySha(fileSha)
-	if err != nil || existingFile == nil {
-		// 文件不存在，返回秒传失败
-		return serializer.ErrorResponse(errors.New("秒传失败，文件不存在"))
-	}
-
-	// 绑定用户文件
-	userFileRecord := &models.UserFile{
-		UserID:   userIDInt,
-		FileID:   existingFile.ID,
-		FileName: fileName,
-		DirID:    s.DirID,
-	}
-
-	if err := userFileRecord.OnUserFileUploadFinished(); err != nil {
+	files, err := models.QueryUserPendingTransfers(userIDInt)
+	if err != nil {
 		return serializer.ErrorResponse(err)
 	}
-
-	return serializer.SuccessResponse(existingFile)
-}
-
-// TODO COS URL下载 关于下载时候的文件名 默认情况下 应该是上传时候指定的 key 如果想保持原来的文件名，可以在上传的时候指定一个元信息
-func (s *FileService) DownloadURL(c *gin.Context) serializer.Response {
-	filesha := c.PostForm("filesha")
-	//从文件表中查找记录
-	file, _ := models.GetFileBySha(filesha)
-
-	// TODO 判断文件存储在Cos还是Ceph中
-
-	//生成下载链接
-	signedURL, _ := cos.DownloadURL(file.Path)
-	return serializer.SuccessResponse(signedURL)
+	transfers := make([]TransferStatus, 0, len(files))
+	for _, f := range files {
+		transfers = append(transfers, TransferStatus{
+			ID:        f.ID,
+			FileName:  f.FileName,
+			FileHash:  f.File.Sha,
+			Size:      f.File.Size,
+			Backend:   f.File.Backend,
+			Status:    f.File.Status,
+			UpdatedAt: f.File.UpdatedAt,
+		})
+	}
+	return serializer.SuccessResponse(transfers)
 }
 
 // RenameFile: 文件重命名
@@ -389,6 +250,9 @@ func (s *RenameFileService) RenameFile(c *gin.Context) serializer.Response {
 	if s.ID == 0 || s.NewFileName == "" {
 		return serializer.ErrorResponse(errors.New("缺少必要参数"))
 	}
+	if err := validateFileName(s.NewFileName); err != nil {
+		return serializer.ErrorResponse(err)
+	}
 
 	// 查找 UserFile
 	var userFile models.UserFile
@@ -407,3 +271,98 @@ func (s *RenameFileService) RenameFile(c *gin.Context) serializer.Response {
 
 	return serializer.SuccessResponse("文件重命名成功")
 }
+
+// CopyFile: 复制文件到指定目录
+func (s *CopyFileService) CopyFile(c *gin.Context) serializer.Response {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return serializer.ErrorResponse(errors.New("user not logged in"))
+	}
+	userIDInt := userID.(uint)
+
+	var source models.UserFile
+	if err := models.DB.First(&source, "id = ? AND user_id = ?", s.ID, userIDInt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return serializer.ErrorResponse(errors.New("文件记录不存在或无权限"))
+		}
+		return serializer.ErrorResponse(err)
+	}
+
+	if s.DirID != 0 {
+		var dir models.UserDir
+		if err := models.DB.First(&dir, "id = ? AND user_id = ?", s.DirID, userIDInt).Error; err != nil {
+			return serializer.ErrorResponse(errors.New("目标目录不存在"))
+		}
+	}
+
+	fileName := s.NewFileName
+	if fileName == "" {
+		fileName = source.FileName
+	} else if err := validateFileName(fileName); err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	userFile := &models.UserFile{
+		UserID:   userIDInt,
+		FileID:   source.FileID,
+		FileName: fileName,
+		DirID:    s.DirID,
+	}
+	// 同一文件的副本按文件名区分, 同名时需要指定新的文件名
+	existing, err := userFile.GetUserFileByIDFileNameAndUser()
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	if existing != nil {
+		return serializer.ErrorResponse(errors.New("已存在同名的文件, 请指定新的文件名"))
+	}
+	if err := userFile.AttachUserFile(); err != nil {
+		return serializer.ErrorResponse(err)
+	}
+
+	return serializer.SuccessResponse(userFile)
+}
+
+// newTransferMessage 生成将本地副本转移到 COS 的任务
+func newTransferMessage(fileSha string) (models.OutboxMessage, error) {
+	localPath, err := firesystem.ObjectKey(cmn.StoreLocal, fileSha)
+	if err != nil {
+		return models.OutboxMessage{}, err
+	}
+	cosPath, err := firesystem.ObjectKey(cmn.StoreCOS, fileSha)
+	if err != nil {
+		return models.OutboxMessage{}, err
+	}
+	return transfer.NewOutboxMessage(rbmq.TransferData{
+		FileHash:      fileSha,
+		CurLocation:   localPath,
+		DestLocation:  cosPath,
+		DestStoreType: cmn.StoreCOS,
+	})
+}
+
+const maxFileNameLength = 255
+
+// validateFileName 校验用户提交的文件名, 拒绝路径分隔符、"." 或 ".." 及控制字符
+func validateFileName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("文件名不能为空")
+	}
+	if len(name) > maxFileNameLength {
+		return fmt.Errorf("文件名不能超过 %d 字节", maxFileNameLength)
+	}
+	if !utf8.ValidString(name) {
+		return errors.New("文件名编码无效")
+	}
+	if name == "." || name == ".." {
+		return errors.New("文件名不能为 \".\" 或 \"..\"")
+	}
+	if strings.ContainsAny(name, `/\`) {
+		return errors.New("文件名不能包含路径分隔符")
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return errors.New("文件名不能包含控制字符")
+		}
+	}
+	return nil
+}
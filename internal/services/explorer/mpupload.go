@@ -2,18 +2,24 @@ package explorer
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"io"
 	"litedrive/internal/cache/redis"
+	"litedrive/internal/firesystem"
+	"litedrive/internal/firesystem/blob"
 	"litedrive/internal/models"
-	"litedrive/internal/utils"
+	cmn "litedrive/pkg/common"
 	"litedrive/pkg/serializer"
 	"log"
 	"math"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -24,30 +30,124 @@ type MultipartUploadInfo struct {
 	UploadID   string `json:"uploadId"`
 	ChunkSize  int    `json:"chunkSize"`
 	ChunkCount int    `json:"chunkCount"`
+	Backend    string `json:"backend"` // 分块直接写入的存储后端, 为空时先暂存在本地
+	Resumed    bool   `json:"resumed"` // 是否为继续之前未完成的上传
+	// 会话过期时间, 每次上传分块后顺延
+	ExpiresAt time.Time `json:"expiresAt"`
+	// 已上传的分块序号, 断点续传时客户端跳过这些分块
+	UploadedChunks []int `json:"uploadedChunks"`
 }
 
+// ChunkStatus 已上传的分块
+type ChunkStatus struct {
+	Index int    `json:"index"`
+	Size  int64  `json:"size"`
+	Hash  string `json:"hash,omitempty"` // 分块的 SHA-256
+}
+
+// MultipartUploadStatus 分块上传进度
+type MultipartUploadStatus struct {
+	UploadID   string        `json:"uploadId"`
+	FileHash   string        `json:"fileHash"`
+	FileSize   int64         `json:"fileSize"`
+	ChunkSize  int           `json:"chunkSize"`
+	ChunkCount int           `json:"chunkCount"`
+	Chunks     []ChunkStatus `json:"chunks"`
+	ExpiresAt  *time.Time    `json:"expiresAt"` // 为空表示不会过期
+}
+
+const defaultChunkSize = 5 * 1024 * 1024 // 5MB
+
+// 当前存储模式只有一个支持原生分块上传的后端时, 分块直接写入该后端的 multipart upload,
+// 否则分块暂存在本地, 合并时再写入各个后端
+
 func InitalMultipartUpload(c *gin.Context) serializer.Response {
 	var fileInfo models.File
 	if err := c.ShouldBindJSON(&fileInfo); err != nil {
 		return serializer.ErrorResponse(errors.New("参数解析错误"))
 	}
 
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return serializer.ErrorResponse(errors.New("用户未登录"))
+	}
+	userIDInt := userID.(uint)
+
+	// 统一为小写, 与合并后计算的摘要、秒传索引及续传会话的 key 一致
+	fileInfo.Sha = strings.ToLower(fileInfo.Sha)
+	if !isValidSha(fileInfo.Sha) {
+		return serializer.ErrorResponse(errors.New("无效的文件哈希"))
+	}
+	if fileInfo.Size <= 0 {
+		return serializer.ErrorResponse(errors.New("无效的文件大小"))
+	}
+
+	// 文件已存在时通过秒传完成, 无需上传
+	existingFile, _, err := rapidCheckCandidate(userIDInt, fileInfo.Sha)
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	if existingFile != nil {
+		return serializer.ErrorResponse(errors.New("文件已存在，请使用秒传"))
+	}
+
+	// 同一用户同一文件存在未完成的上传时, 直接返回该会话
+	if resumed, ok := resumeMultipartUpload(userIDInt, fileInfo.Sha, fileInfo.Size); ok {
+		return serializer.SuccessResponse(resumed)
+	}
+
+	// 上传 ID 需要不可预测, 会话同时绑定发起上传的用户
+	uploadID, err := newUploadID()
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
 	upInfo := MultipartUploadInfo{
-		FileHash:   fileInfo.Sha,
-		FileSize:   fileInfo.Size,
-		UploadID:   strconv.FormatInt(time.Now().UnixNano(), 10),
-		ChunkSize:  5 * 1024 * 1024, // 5MB
-		ChunkCount: int(math.Ceil(float64(fileInfo.Size) / (5 * 1024 * 1024))),
+		FileHash:       fileInfo.Sha,
+		FileSize:       fileInfo.Size,
+		UploadID:       uploadID,
+		ChunkSize:      defaultChunkSize,
+		ChunkCount:     int(math.Ceil(float64(fileInfo.Size) / defaultChunkSize)),
+		UploadedChunks: []int{},
+	}
+
+	targets, err := blob.Placement(fileInfo.Size, userIDInt)
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	objectKey, multipartID := "", ""
+	if len(targets) == 1 {
+		if mp, ok := multipartDriver(targets[0]); ok {
+			objectKey, err = firesystem.ObjectKey(targets[0], upInfo.FileHash+"."+upInfo.UploadID)
+			if err != nil {
+				return serializer.ErrorResponse(err)
+			}
+			multipartID, err = mp.CreateMultipart(c.Request.Context(), objectKey)
+			if err != nil {
+				return serializer.ErrorResponse(err)
+			}
+			upInfo.Backend = targets[0].String()
+		}
 	}
 
 	ctx, cancel := context.WithTimeout(redis.Ctx, 3*time.Second)
 	defer cancel()
 
-	err := redis.RedisCli.HSet(ctx, "MP_"+upInfo.UploadID,
+	err = redis.RedisCli.HSet(ctx, mpKey(upInfo.UploadID),
+		"user_id", userIDInt,
 		"filehash", upInfo.FileHash,
 		"filesize", upInfo.FileSize,
+		"chunksize", upInfo.ChunkSize,
 		"chunkcount", upInfo.ChunkCount,
+		"backend", upInfo.Backend,
+		"object_key", objectKey,
+		"multipart_id", multipartID,
 	).Err()
+	if err == nil {
+		err = redis.RedisCli.Set(ctx, mpUserKey(userIDInt, upInfo.FileHash), upInfo.UploadID, 0).Err()
+	}
+	if err == nil {
+		upInfo.ExpiresAt, err = touchSession(ctx, upInfo.UploadID, userIDInt, upInfo.FileHash)
+	}
 	if err != nil {
 		log.Printf("Redis HSet 失败: %v", err)
 		return serializer.ErrorResponse(errors.New("Redis 写入失败"))
@@ -56,6 +156,95 @@ func InitalMultipartUpload(c *gin.Context) serializer.Response {
 	return serializer.SuccessResponse(upInfo)
 }
 
+// resumeMultipartUpload 查找用户对同一文件未完成的上传会话
+func resumeMultipartUpload(userID uint, fileHash string, fileSize int64) (*MultipartUploadInfo, bool) {
+	ctx, cancel := context.WithTimeout(redis.Ctx, 3*time.Second)
+	defer cancel()
+
+	uploadID, err := redis.RedisCli.Get(ctx, mpUserKey(userID, fileHash)).Result()
+	if err != nil {
+		return nil, false
+	}
+	session, _, err := loadSession(ctx, uploadID)
+	if err != nil {
+		return nil, false
+	}
+	status := uploadStatus(uploadID, session, time.Time{})
+	if status.FileSize != fileSize {
+		return nil, false
+	}
+	expiresAt, err := touchSession(ctx, uploadID, userID, fileHash)
+	if err != nil {
+		return nil, false
+	}
+
+	uploaded := make([]int, 0, len(status.Chunks))
+	for _, chunk := range status.Chunks {
+		uploaded = append(uploaded, chunk.Index)
+	}
+	return &MultipartUploadInfo{
+		FileHash:       status.FileHash,
+		FileSize:       status.FileSize,
+		UploadID:       uploadID,
+		ChunkSize:      status.ChunkSize,
+		ChunkCount:     status.ChunkCount,
+		Backend:        session["backend"],
+		Resumed:        true,
+		ExpiresAt:      expiresAt,
+		UploadedChunks: uploaded,
+	}, true
+}
+
+// uploadStatus 根据 Redis 中的会话信息整理上传进度
+func uploadStatus(uploadID string, session map[string]string, expiresAt time.Time) *MultipartUploadStatus {
+	fileSize, _ := strconv.ParseInt(session["filesize"], 10, 64)
+	chunkSize, _ := strconv.Atoi(session["chunksize"])
+	if chunkSize == 0 {
+		chunkSize = defaultChunkSize
+	}
+	chunkCount, _ := strconv.Atoi(session["chunkcount"])
+
+	status := &MultipartUploadStatus{
+		UploadID:   uploadID,
+		FileHash:   session["filehash"],
+		FileSize:   fileSize,
+		ChunkSize:  chunkSize,
+		ChunkCount: chunkCount,
+		Chunks:     []ChunkStatus{},
+	}
+	for i := 0; i < chunkCount; i++ {
+		if _, ok := session["chunk_"+strconv.Itoa(i)]; !ok {
+			continue
+		}
+		size, _ := strconv.ParseInt(session["size_"+strconv.Itoa(i)], 10, 64)
+		status.Chunks = append(status.Chunks, ChunkStatus{Index: i, Size: size, Hash: session["hash_"+strconv.Itoa(i)]})
+	}
+	if !expiresAt.IsZero() {
+		status.ExpiresAt = &expiresAt
+	}
+	return status
+}
+
+// GetMultipartUploadStatus 查询分块上传进度, 返回已上传的分块序号及大小
+func GetMultipartUploadStatus(c *gin.Context) serializer.Response {
+	uploadID := c.Param("uploadID")
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return serializer.ErrorResponse(errors.New("用户未登录"))
+	}
+
+	ctx, cancel := context.WithTimeout(redis.Ctx, 3*time.Second)
+	defer cancel()
+
+	session, expiresAt, err := loadOwnedSession(ctx, uploadID, userID.(uint))
+	if err != nil {
+		return sessionErrorResponse(err)
+	}
+
+	return serializer.SuccessResponse(uploadStatus(uploadID, session, expiresAt))
+}
+
 func UploadPart(c *gin.Context) serializer.Response {
 	uploadID := c.PostForm("upload_id")
 	chunkIndexStr := c.PostForm("chunk_index")
@@ -64,64 +253,183 @@ func UploadPart(c *gin.Context) serializer.Response {
 		return serializer.ErrorResponse(errors.New("chunk_index 解析失败"))
 	}
 
-	//type req struct {
-	//	UserID     int    `json:"user_id"`
-	//	UploadID   string `json:"upload_id"`
-	//	ChunkIndex int    `json:"chunk_index"`
-	//}
-	//var reqInfo req
-	//if err := c.ShouldBindJSON(&reqInfo); err != nil {
-	//	return serializer.ErrorResponse(errors.New("参数解析错误"))
-	//}
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return serializer.ErrorResponse(errors.New("用户未登录"))
+	}
+	userIDInt := userID.(uint)
 
-	config, err := utils.LoadConfig()
+	ctx, cancel := context.WithTimeout(redis.Ctx, 3*time.Second)
+	defer cancel()
+
+	session, _, err := loadOwnedSession(ctx, uploadID, userIDInt)
 	if err != nil {
-		return serializer.ErrorResponse(errors.New("配置文件加载失败"))
+		return sessionErrorResponse(err)
 	}
 
-	chunkDir := filepath.Join(config.Storage.Root, uploadID)
-	if err := os.MkdirAll(chunkDir, os.ModePerm); err != nil {
-		return serializer.ErrorResponse(errors.New("无法创建存储目录"))
+	file, header, err := c.Request.FormFile("chunk")
+	if err != nil {
+		return serializer.ErrorResponse(errors.New("文件上传失败"))
 	}
+	defer file.Close()
 
-	chunkPath := filepath.Join(chunkDir, strconv.Itoa(chunkIndex))
-	fd, err := os.Create(chunkPath)
+	status := uploadStatus(uploadID, session, time.Time{})
+	if chunkIndex < 0 || chunkIndex >= status.ChunkCount {
+		return serializer.ErrorResponse(fmt.Errorf("chunk_index 超出范围 [0, %d)", status.ChunkCount))
+	}
+	if expected := expectedChunkSize(status, chunkIndex); header.Size != expected {
+		return serializer.ErrorResponse(fmt.Errorf("分块大小不匹配: 期望 %d, 实际 %d", expected, header.Size))
+	}
+	// 客户端可以携带分块的 SHA-256, 服务端写入时同步计算并校验
+	chunkHash := strings.ToLower(c.PostForm("chunk_hash"))
+	if chunkHash != "" && !isValidSha(chunkHash) {
+		return serializer.ErrorResponse(errors.New("无效的分块哈希"))
+	}
+
+	hash := sha256.New()
+	reader := io.TeeReader(file, hash)
+	fields := []interface{}{
+		"chunk_" + strconv.Itoa(chunkIndex), 1,
+		"size_" + strconv.Itoa(chunkIndex), header.Size,
+	}
+	remote := session["object_key"] != ""
+	if remote {
+		if session["multipart_id"] == "" {
+			return serializer.ErrorResponse(errors.New("分块已合并, 无法继续上传"))
+		}
+		// 分块直接写入存储后端, 分块号从 1 开始
+		mp, ok := multipartDriver(cmn.ParseStoreType(session["backend"]))
+		if !ok {
+			return serializer.ErrorResponse(errors.New("存储后端不支持分块上传"))
+		}
+		etag, err := mp.UploadPart(c.Request.Context(), session["object_key"], session["multipart_id"], chunkIndex+1, reader, header.Size)
+		if err != nil {
+			return serializer.ErrorResponse(err)
+		}
+		fields = append(fields, "etag_"+strconv.Itoa(chunkIndex), etag)
+	} else if err := saveLocalChunk(uploadID, chunkIndex, reader); err != nil {
+		return serializer.ErrorResponse(err)
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if chunkHash != "" && sum != chunkHash {
+		// 校验失败的分块不计入进度, 本地分块直接删除, 远端分块由客户端重传覆盖
+		if !remote {
+			removeLocalChunk(uploadID, chunkIndex)
+		}
+		redis.RedisCli.HDel(redis.Ctx, mpKey(uploadID),
+			"chunk_"+strconv.Itoa(chunkIndex), "size_"+strconv.Itoa(chunkIndex),
+			"hash_"+strconv.Itoa(chunkIndex), "etag_"+strconv.Itoa(chunkIndex))
+		return serializer.ErrorResponse(fmt.Errorf("分块哈希不匹配: 期望 %s, 实际 %s", chunkHash, sum), "分块校验失败")
+	}
+	fields = append(fields, "hash_"+strconv.Itoa(chunkIndex), sum)
+
+	err = redis.RedisCli.HSet(redis.Ctx, mpKey(uploadID), fields...).Err()
 	if err != nil {
-		return serializer.ErrorResponse(errors.New("无法创建分块文件"))
+		return serializer.ErrorResponse(errors.New("Redis 更新失败"))
+	}
+	if _, err := touchSession(redis.Ctx, uploadID, userIDInt, session["filehash"]); err != nil {
+		log.Printf("顺延上传会话 %s 失败: %v", uploadID, err)
 	}
-	defer fd.Close()
 
-	file, _, err := c.Request.FormFile("chunk")
+	return serializer.SuccessResponse("分块上传成功")
+}
+
+// saveLocalChunk 将分块暂存到本地
+func saveLocalChunk(uploadID string, chunkIndex int, file io.Reader) error {
+	dir, err := chunkDir(uploadID)
 	if err != nil {
-		return serializer.ErrorResponse(errors.New("文件上传失败"))
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return errors.New("无法创建存储目录")
 	}
-	defer file.Close()
+
+	chunkPath := filepath.Join(dir, strconv.Itoa(chunkIndex))
+	fd, err := os.Create(chunkPath)
+	if err != nil {
+		return errors.New("无法创建分块文件")
+	}
+	defer fd.Close()
 
 	buf := make([]byte, 1024*1024)
 	for {
 		n, err := file.Read(buf)
+		if n > 0 {
+			if _, err := fd.Write(buf[:n]); err != nil {
+				return errors.New("文件写入失败")
+			}
+		}
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			return serializer.ErrorResponse(errors.New("文件读取错误"))
+			return errors.New("文件读取错误")
 		}
-		if _, err := fd.Write(buf[:n]); err != nil {
-			return serializer.ErrorResponse(errors.New("文件写入失败"))
+	}
+	return nil
+}
+
+// expectedChunkSize 除最后一个分块外, 每个分块大小都等于 ChunkSize
+func expectedChunkSize(status *MultipartUploadStatus, chunkIndex int) int64 {
+	if chunkIndex == status.ChunkCount-1 {
+		return status.FileSize - int64(status.ChunkSize)*int64(status.ChunkCount-1)
+	}
+	return int64(status.ChunkSize)
+}
+
+// missingChunks 返回尚未上传的分块序号
+func missingChunks(status *MultipartUploadStatus) []int {
+	uploaded := make(map[int]bool, len(status.Chunks))
+	for _, chunk := range status.Chunks {
+		uploaded[chunk.Index] = true
+	}
+	missing := []int{}
+	for i := 0; i < status.ChunkCount; i++ {
+		if !uploaded[i] {
+			missing = append(missing, i)
 		}
 	}
+	return missing
+}
+
+// AbortMultipartUpload 取消分块上传, 删除已上传的分块
+func AbortMultipartUpload(c *gin.Context) serializer.Response {
+	var reqInfo struct {
+		UploadID string `json:"upload_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&reqInfo); err != nil {
+		return serializer.ErrorResponse(errors.New("参数解析错误"))
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return serializer.ErrorResponse(errors.New("用户未登录"))
+	}
 
 	ctx, cancel := context.WithTimeout(redis.Ctx, 3*time.Second)
 	defer cancel()
 
-	err = redis.RedisCli.HSet(ctx, "MP_"+uploadID,
-		"chunk_"+strconv.Itoa(chunkIndex), 1,
-	).Err()
+	session, _, err := loadOwnedSession(ctx, reqInfo.UploadID, userID.(uint))
 	if err != nil {
-		return serializer.ErrorResponse(errors.New("Redis 更新失败"))
+		return sessionErrorResponse(err)
 	}
+	discardMultipartUpload(reqInfo.UploadID, session)
 
-	return serializer.SuccessResponse("分块上传成功")
+	return serializer.SuccessResponse("分块上传已取消")
+}
+
+// multipartDriver 获取支持原生分块上传的存储驱动
+func multipartDriver(storeType cmn.StoreType) (firesystem.MultipartDriver, bool) {
+	if storeType == cmn.StoreLocal {
+		return nil, false
+	}
+	driver, err := firesystem.GetDriver(storeType)
+	if err != nil {
+		return nil, false
+	}
+	mp, ok := driver.(firesystem.MultipartDriver)
+	return mp, ok
 }
 
 func CompleteMultipartUpload(c *gin.Context) serializer.Response {
@@ -139,95 +447,205 @@ func CompleteMultipartUpload(c *gin.Context) serializer.Response {
 	if reqInfo.UploadID == "" {
 		return serializer.ErrorResponse(errors.New("upload_id 不能为空"))
 	}
+	if err := validateFileName(reqInfo.FileName); err != nil {
+		return serializer.ErrorResponse(err)
+	}
 
-	config, err := utils.LoadConfig()
-	if err != nil {
-		return serializer.ErrorResponse(errors.New("配置文件加载失败"))
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return serializer.ErrorResponse(errors.New("用户未登录"))
 	}
+	userIDInt := userID.(uint)
 
 	ctx, cancel := context.WithTimeout(redis.Ctx, 3*time.Second)
 	defer cancel()
 
-	chunkCountStr, err := redis.RedisCli.HGet(ctx, "MP_"+reqInfo.UploadID, "chunkcount").Result()
+	session, _, err := loadOwnedSession(ctx, reqInfo.UploadID, userIDInt)
+	if err != nil {
+		return sessionErrorResponse(err)
+	}
+	status := uploadStatus(reqInfo.UploadID, session, time.Time{})
+	fileHash := status.FileHash
+
+	// 分块不完整时返回缺失的分块序号, 客户端补传后再次合并
+	if missing := missingChunks(status); len(missing) > 0 {
+		res := serializer.ErrorResponse(fmt.Errorf("缺少 %d 个分块", len(missing)), "分块不完整")
+		res.Data = gin.H{"missingChunks": missing}
+		return res
+	}
+
+	var replicas []models.FileReplica
+	if session["object_key"] != "" {
+		replicas, err = completeRemoteChunks(c, session, status)
+	} else {
+		replicas, err = completeLocalChunks(c, status, userIDInt)
+	}
+	if errors.Is(err, errContentMismatch) {
+		// 合并后的内容与声明的不一致, 整个上传作废
+		discardMultipartUpload(reqInfo.UploadID, session)
+		return serializer.ErrorResponse(err, "文件校验失败")
+	}
 	if err != nil {
-		return serializer.ErrorResponse(errors.New("无法获取分块数"))
+		return serializer.ErrorResponse(err)
 	}
-	chunkCount, _ := strconv.Atoi(chunkCountStr)
 
-	fileHash, err := redis.RedisCli.HGet(ctx, "MP_"+reqInfo.UploadID, "filehash").Result()
+	// 将文件与用户关联
+	userFile := &models.UserFile{
+		UserID:   userIDInt, // 当前用户ID
+		FileName: reqInfo.FileName,
+		DirID:    reqInfo.DirId,
+		Status:   "active", // 文件状态
+	}
+
+	var file *models.File
+	if len(replicas) == 0 {
+		// 相同内容的文件已存在, 没有写入新的副本
+		file, err = models.GetFileBySha(fileHash)
+		if err == nil && file == nil {
+			err = errors.New("文件已被删除, 请重新上传")
+		}
+		if err == nil {
+			userFile.FileID = file.ID
+			err = userFile.AttachUserFile()
+		}
+	} else {
+		// 在数据库中写入文件信息, 并发上传相同内容时关联先写入的文件
+		file, _, err = saveUploadedFile(c.Request.Context(), &models.File{
+			Sha:      fileHash,            // 文件哈希
+			Size:     status.FileSize,     // 文件大小
+			Path:     replicas[0].Path,    // 主副本的存储路径
+			Backend:  replicas[0].Backend, // 主副本所在的存储后端
+			Replicas: replicas,            // 所有副本
+		}, userFile)
+	}
 	if err != nil {
-		return serializer.ErrorResponse(errors.New("无法获取文件 hash"))
+		// 保留会话及分块, 客户端可以重试合并; 远端分块已合并时重试直接校验对象
+		return serializer.ErrorResponse(err, "文件信息写入数据库失败")
 	}
 
-	mergedFilePath := filepath.Join(config.Storage.Root, fileHash)
-	outFile, err := os.Create(mergedFilePath)
+	// 文件记录写入成功后才删除存储块文件的临时文件夹及 Redis 记录
+	discardMultipartUpload(reqInfo.UploadID, session)
+
+	return serializer.SuccessResponse(map[string]interface{}{
+		"message":   "文件上传完成",
+		"file_path": file.Path,
+		"file_size": status.FileSize,
+		"file_hash": fileHash,
+	})
+}
+
+// completeLocalChunks 将本地暂存分块拼接后写入当前存储模式下的各个后端, 哈希校验通过后确定最终位置
+// 相同内容的文件已存在时不写入, 返回空的副本列表
+// 先校验再写入, 避免内容不符的数据以声明的哈希为 key 覆盖已有对象
+func completeLocalChunks(c *gin.Context, status *MultipartUploadStatus, userID uint) ([]models.FileReplica, error) {
+	dir, err := chunkDir(status.UploadID)
 	if err != nil {
-		return serializer.ErrorResponse(errors.New("创建合并文件失败"))
+		return nil, err
 	}
-	defer outFile.Close()
 
-	for i := 0; i < chunkCount; i++ {
-		chunkPath := filepath.Join(config.Storage.Root, reqInfo.UploadID, strconv.Itoa(i))
+	var fileSize int64
+	chunkFiles := make([]*os.File, 0, status.ChunkCount)
+	defer func() {
+		for _, f := range chunkFiles {
+			f.Close()
+		}
+	}()
+	for i := 0; i < status.ChunkCount; i++ {
+		chunkPath := filepath.Join(dir, strconv.Itoa(i))
 		chunkFile, err := os.Open(chunkPath)
 		if err != nil {
-			return serializer.ErrorResponse(errors.New("读取分块失败"))
+			return nil, fmt.Errorf("%w: 分块 %d 读取失败", errContentMismatch, i)
 		}
-		_, err = io.Copy(outFile, chunkFile)
-		chunkFile.Close()
+		chunkFiles = append(chunkFiles, chunkFile)
+		fi, err := chunkFile.Stat()
 		if err != nil {
-			return serializer.ErrorResponse(errors.New("合并分块失败"))
+			return nil, errors.New("读取分块失败")
 		}
-		os.Remove(chunkPath)
+		fileSize += fi.Size()
+	}
+	if fileSize != status.FileSize {
+		return nil, fmt.Errorf("%w: 文件大小期望 %d, 实际 %d", errContentMismatch, status.FileSize, fileSize)
 	}
 
-	// 删除存储块文件的临时文件夹
-	chunkDir := filepath.Join(config.Storage.Root, reqInfo.UploadID)
-	if err := os.RemoveAll(chunkDir); err != nil {
-		log.Printf("删除临时目录失败: %v", err)
+	rs := make([]io.Reader, 0, len(chunkFiles))
+	for _, f := range chunkFiles {
+		rs = append(rs, f)
 	}
 
-	fileInfo, err := outFile.Stat()
+	targets, err := blob.Placement(fileSize, userID)
 	if err != nil {
-		return serializer.ErrorResponse(errors.New("获取合并文件信息失败"))
+		return nil, err
+	}
+	// 读取分块一次, 同时计算哈希并写入各个后端的临时位置
+	staged, err := blob.Stage(c.Request.Context(), targets, io.MultiReader(rs...), fileSize)
+	if err != nil {
+		return nil, errors.New("合并分块失败")
+	}
+	if staged.Sha != status.FileHash {
+		staged.Discard(c.Request.Context())
+		return nil, fmt.Errorf("%w: 文件哈希期望 %s, 实际 %s", errContentMismatch, status.FileHash, staged.Sha)
 	}
 
-	redis.RedisCli.Del(ctx, "MP_"+reqInfo.UploadID)
-
-	// 在数据库中写入文件信息
-	var file models.File
-	file = models.File{
-		Sha:  fileHash,        // 文件哈希
-		Size: fileInfo.Size(), // 文件大小
-		Path: mergedFilePath,  // 文件的存储路径
+	// 内容校验通过后才能去重, 相同内容已存在时丢弃本次写入
+	existing, err := models.GetFileBySha(status.FileHash)
+	if err != nil {
+		staged.Discard(c.Request.Context())
+		return nil, err
 	}
-	err = models.DB.Create(&file).Error
+	if existing != nil {
+		staged.Discard(c.Request.Context())
+		return nil, nil
+	}
+
+	replicas, err := staged.Commit(c.Request.Context())
 	if err != nil {
-		return serializer.ErrorResponse(errors.New("文件信息写入数据库失败"))
+		return nil, errors.New("合并分块失败")
 	}
+	return replicas, nil
+}
 
-	userID, exists := c.Get("user_id")
-	if !exists {
-		return serializer.ErrorResponse(errors.New("用户未登录"))
+// completeRemoteChunks 合并存储后端中的 multipart upload, 并校验合并后对象的大小及哈希
+func completeRemoteChunks(c *gin.Context, session map[string]string, status *MultipartUploadStatus) ([]models.FileReplica, error) {
+	storeType := cmn.ParseStoreType(session["backend"])
+	mp, ok := multipartDriver(storeType)
+	if !ok {
+		return nil, errors.New("存储后端不支持分块上传")
 	}
-	userIDInt := userID.(uint)
 
-	// 将文件与用户关联
-	userFile := models.UserFile{
-		UserID:   userIDInt, // 当前用户ID
-		FileID:   file.ID,   // 文件ID（刚刚插入的 file 记录的 ID）
-		FileName: reqInfo.FileName,
-		DirID:    reqInfo.DirId,
-		Status:   "active", // 文件状态
+	objectKey := session["object_key"]
+	if multipartID := session["multipart_id"]; multipartID != "" {
+		parts := make([]firesystem.Part, 0, status.ChunkCount)
+		for i := 0; i < status.ChunkCount; i++ {
+			etag, ok := session["etag_"+strconv.Itoa(i)]
+			if !ok {
+				return nil, errors.New("读取分块失败")
+			}
+			parts = append(parts, firesystem.Part{Number: i + 1, ETag: etag})
+		}
+		if err := mp.CompleteMultipart(c.Request.Context(), objectKey, multipartID, parts); err != nil {
+			return nil, errors.New("合并分块失败")
+		}
+		// 分块已合并, 清理会话时无需再取消 multipart upload, 重试合并时直接校验对象
+		session["multipart_id"] = ""
+		redis.RedisCli.HSet(redis.Ctx, mpKey(status.UploadID), "multipart_id", "")
+	}
+
+	driver, err := firesystem.GetDriver(storeType)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyObject(c.Request.Context(), driver, objectKey, status.FileHash, status.FileSize)
+	if errors.Is(err, errContentMismatch) || errors.Is(err, errObjectMissing) {
+		// multipart upload 已合并, 删除校验失败的对象
+		if delErr := driver.Delete(context.Background(), objectKey); delErr != nil {
+			log.Printf("删除校验失败的对象失败: %v", delErr)
+		}
+		return nil, fmt.Errorf("%w: %v", errContentMismatch, err)
 	}
-	err = models.DB.Create(&userFile).Error
 	if err != nil {
-		return serializer.ErrorResponse(errors.New("用户文件关联写入失败"))
+		return nil, err
 	}
 
-	return serializer.SuccessResponse(map[string]interface{}{
-		"message":   "文件上传完成",
-		"file_path": mergedFilePath,
-		"file_size": fileInfo.Size(),
-		"file_hash": fileHash,
-	})
+	replicas := []models.FileReplica{{Backend: storeType.String(), Path: objectKey, Status: "active"}}
+	return replicas, nil
 }
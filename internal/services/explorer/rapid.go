@@ -0,0 +1,293 @@
+package explorer
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"litedrive/internal/cache/redis"
+	"litedrive/internal/firesystem"
+	"litedrive/internal/firesystem/blob"
+	"litedrive/internal/models"
+	"litedrive/internal/utils"
+	"litedrive/pkg/serializer"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 秒传分两步: 客户端先提交文件哈希, 服务端生成随机 nonce 并挑选几段字节范围作为挑战,
+// 客户端返回每段范围的 SHA-256(nonce || 范围内容), 与存储中的文件比对一致后才关联文件, 避免只凭哈希获取他人文件
+// 应答依赖 nonce, 即使挑战范围覆盖整个小文件, 也无法用文件哈希代替
+
+const (
+	rapidCheckRanges    = 3
+	rapidCheckRangeSize = 64 * 1024
+	rapidCheckTTL       = 5 * time.Minute
+	rapidNonceSize      = 16
+
+	// RapidCheckScopeGlobal 可以秒传任意用户上传过的文件
+	RapidCheckScopeGlobal = "global"
+	// RapidCheckScopeUser 只能秒传自己上传过的文件
+	RapidCheckScopeUser = "user"
+)
+
+type RapidCheckService struct {
+	FileName string `json:"fileName"`
+	FileHash string `json:"fileHash"`
+	DirID    uint   `json:"dirId"`
+}
+
+// RapidVerifyService 秒传挑战应答, Hashes 与挑战中的范围一一对应
+type RapidVerifyService struct {
+	ChallengeID string   `json:"challengeId" binding:"required"`
+	Hashes      []string `json:"hashes"`
+}
+
+// ByteRange 文件中的一段字节范围
+type ByteRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// RapidChallenge 秒传挑战, 客户端需要对每段范围计算 SHA-256(nonce || 范围内容)
+type RapidChallenge struct {
+	ChallengeID string      `json:"challengeId"`
+	Nonce       string      `json:"nonce"` // 十六进制编码, 计算哈希时使用解码后的字节
+	Ranges      []ByteRange `json:"ranges"`
+	ExpiresAt   time.Time   `json:"expiresAt"`
+}
+
+func rapidKey(challengeID string) string {
+	return "RC_" + challengeID
+}
+
+// rapidCheckCandidate 按秒传范围查找可以秒传的文件, owned 表示用户已经拥有该文件
+func rapidCheckCandidate(userID uint, fileHash string) (file *models.File, owned bool, err error) {
+	file, err = models.GetFileBySha(fileHash)
+	if err != nil || file == nil {
+		return nil, false, err
+	}
+	var count int64
+	if err := models.DB.Model(&models.UserFile{}).Where("user_id = ? AND file_id = ?", userID, file.ID).Count(&count).Error; err != nil {
+		return nil, false, err
+	}
+	if count > 0 {
+		return file, true, nil
+	}
+
+	config, err := utils.LoadConfig()
+	if err != nil {
+		return nil, false, err
+	}
+	if config.Storage.RapidCheckScope == RapidCheckScopeUser {
+		return nil, false, nil
+	}
+	return file, false, nil
+}
+
+// RapidCheck:秒传逻辑接口, 文件存在时返回挑战, 用户已拥有该文件时直接完成秒传
+func (s *RapidCheckService) RapidCheck(c *gin.Context) serializer.Response {
+	// 获取上下文中的 user_id
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return serializer.ErrorResponse(errors.New("user not logged in"))
+	}
+	userIDInt := userID.(uint)
+
+	// 从前端请求获取文件 SHA256 和 文件名
+	fileSha := strings.ToLower(s.FileHash)
+	fileName := s.FileName
+	if fileSha == "" {
+		return serializer.ErrorResponse(errors.New("file SHA256 is required"))
+	}
+	if err := validateFileName(fileName); err != nil {
+		return serializer.ErrorResponse(err)
+	}
+
+	// 查找数据库，看该哈希值是否存在
+	existingFile, owned, err := rapidCheckCandidate(userIDInt, fileSha)
+	if err != nil || existingFile == nil {
+		// 文件不存在，返回秒传失败
+		return serializer.ErrorResponse(errors.New("秒传失败，文件不存在"))
+	}
+
+	// 用户已经拥有该文件, 无需证明
+	if owned {
+		return attachRapidFile(userIDInt, existingFile, fileName, s.DirID)
+	}
+
+	challengeID, err := newUploadID()
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	ranges, err := randomRanges(existingFile.Size)
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	nonce := make([]byte, rapidNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	rangesJSON, _ := json.Marshal(ranges)
+
+	ctx, cancel := context.WithTimeout(redis.Ctx, 3*time.Second)
+	defer cancel()
+
+	key := rapidKey(challengeID)
+	err = redis.RedisCli.HSet(ctx, key,
+		"user_id", userIDInt,
+		"file_id", existingFile.ID,
+		"filename", fileName,
+		"dir_id", s.DirID,
+		"ranges", string(rangesJSON),
+		"nonce", hex.EncodeToString(nonce),
+	).Err()
+	if err == nil {
+		err = redis.RedisCli.Expire(ctx, key, rapidCheckTTL).Err()
+	}
+	if err != nil {
+		return serializer.ErrorResponse(errors.New("Redis 写入失败"))
+	}
+
+	return serializer.SuccessResponse(RapidChallenge{
+		ChallengeID: challengeID,
+		Nonce:       hex.EncodeToString(nonce),
+		Ranges:      ranges,
+		ExpiresAt:   time.Now().Add(rapidCheckTTL),
+	}, "请计算指定范围的哈希完成秒传")
+}
+
+// RapidVerify 校验秒传挑战的应答, 通过后关联文件
+func (s *RapidVerifyService) RapidVerify(c *gin.Context) serializer.Response {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return serializer.ErrorResponse(errors.New("user not logged in"))
+	}
+	userIDInt := userID.(uint)
+
+	ctx, cancel := context.WithTimeout(redis.Ctx, 3*time.Second)
+	defer cancel()
+
+	// 挑战只能使用一次, 读取后立即删除
+	key := rapidKey(s.ChallengeID)
+	pipe := redis.RedisCli.TxPipeline()
+	get := pipe.HGetAll(ctx, key)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return serializer.ErrorResponse(errors.New("Redis 读取失败"))
+	}
+	challenge := get.Val()
+	if len(challenge) == 0 {
+		return serializer.ErrorResponse(errors.New("秒传挑战不存在或已过期"))
+	}
+	if challenge["user_id"] != strconv.FormatUint(uint64(userIDInt), 10) {
+		return serializer.ForbiddenResponse(errors.New("无权使用该秒传挑战"))
+	}
+
+	var ranges []ByteRange
+	if err := json.Unmarshal([]byte(challenge["ranges"]), &ranges); err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	nonce, err := hex.DecodeString(challenge["nonce"])
+	if err != nil || len(nonce) == 0 {
+		return serializer.ErrorResponse(errors.New("秒传挑战无效"))
+	}
+	if len(s.Hashes) != len(ranges) {
+		return serializer.ErrorResponse(fmt.Errorf("需要 %d 个哈希", len(ranges)), "秒传失败")
+	}
+
+	file, err := models.GetFileByID(challenge["file_id"])
+	if err != nil {
+		return serializer.ErrorResponse(errors.New("秒传失败，文件不存在"))
+	}
+	if err := verifyRanges(c.Request.Context(), file, nonce, ranges, s.Hashes); err != nil {
+		return serializer.ErrorResponse(err, "秒传失败")
+	}
+
+	dirID, _ := strconv.ParseUint(challenge["dir_id"], 10, 64)
+	return attachRapidFile(userIDInt, file, challenge["filename"], uint(dirID))
+}
+
+// attachRapidFile 秒传成功, 将已有文件关联到用户
+func attachRapidFile(userID uint, file *models.File, fileName string, dirID uint) serializer.Response {
+	userFileRecord := &models.UserFile{
+		UserID:   userID,
+		FileID:   file.ID,
+		FileName: fileName,
+		DirID:    dirID,
+	}
+	if err := userFileRecord.AttachUserFile(); err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	return serializer.SuccessResponse(file)
+}
+
+// randomRanges 在文件中随机挑选挑战范围, 文件较小时直接使用整个文件
+// 空文件也返回一段空范围, 应答为 SHA-256(nonce)
+func randomRanges(size int64) ([]ByteRange, error) {
+	if size <= rapidCheckRangeSize {
+		return []ByteRange{{Offset: 0, Length: size}}, nil
+	}
+
+	ranges := make([]ByteRange, 0, rapidCheckRanges)
+	for i := 0; i < rapidCheckRanges; i++ {
+		var b [8]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return nil, err
+		}
+		offset := int64(binary.BigEndian.Uint64(b[:]) % uint64(size-rapidCheckRangeSize+1))
+		ranges = append(ranges, ByteRange{Offset: offset, Length: rapidCheckRangeSize})
+	}
+	return ranges, nil
+}
+
+// verifyRanges 读取存储中的文件, 校验每段范围的应答
+func verifyRanges(ctx context.Context, file *models.File, nonce []byte, ranges []ByteRange, hashes []string) error {
+	loc, err := blob.Locate(ctx, file)
+	if err != nil {
+		return err
+	}
+	return verifyRangesAt(ctx, loc.Driver, loc.Key, nonce, ranges, hashes)
+}
+
+// verifyRangesAt 校验对象中每段范围的 SHA-256(nonce || 范围内容)
+func verifyRangesAt(ctx context.Context, driver firesystem.Driver, key string, nonce []byte, ranges []ByteRange, hashes []string) error {
+	if len(ranges) == 0 || len(ranges) != len(hashes) {
+		return errors.New("文件内容校验失败")
+	}
+	for i, r := range ranges {
+		expected, err := rangeAnswer(ctx, driver, key, nonce, r)
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(hashes[i]))) != 1 {
+			return errors.New("文件内容校验失败")
+		}
+	}
+	return nil
+}
+
+// rangeAnswer 计算一段范围的应答
+func rangeAnswer(ctx context.Context, driver firesystem.Driver, key string, nonce []byte, r ByteRange) (string, error) {
+	hash := sha256.New()
+	hash.Write(nonce)
+	if r.Length > 0 {
+		reader, err := driver.GetRange(ctx, key, r.Offset, r.Length)
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		if _, err := io.Copy(hash, reader); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,85 @@
+package explorer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"litedrive/internal/firesystem/local"
+	"testing"
+)
+
+// putObject 将 content 写入临时目录中的本地存储, 返回驱动及对象 key
+func putObject(t *testing.T, content []byte) (*local.Driver, string) {
+	t.Helper()
+	d := &local.Driver{Root: t.TempDir()}
+	key := "object"
+	if err := d.Put(context.Background(), key, bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("写入对象失败: %v", err)
+	}
+	return d, key
+}
+
+func newNonce(t *testing.T) []byte {
+	t.Helper()
+	nonce := make([]byte, rapidNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	return nonce
+}
+
+// 小文件的挑战范围覆盖整个文件, 只知道文件哈希时不能通过校验
+func TestVerifyRangesRejectsFileHashForSmallFile(t *testing.T) {
+	for _, size := range []int{0, 1, 1000, rapidCheckRangeSize} {
+		content := make([]byte, size)
+		rand.Read(content)
+		sum := sha256.Sum256(content)
+		fileHash := hex.EncodeToString(sum[:])
+
+		d, key := putObject(t, content)
+		ranges, err := randomRanges(int64(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ranges) == 0 {
+			t.Fatalf("大小 %d: 没有挑战范围", size)
+		}
+		hashes := make([]string, len(ranges))
+		for i := range hashes {
+			hashes[i] = fileHash
+		}
+		if err := verifyRangesAt(context.Background(), d, key, newNonce(t), ranges, hashes); err == nil {
+			t.Fatalf("大小 %d: 使用文件哈希作为应答通过了校验", size)
+		}
+	}
+}
+
+func TestVerifyRangesAcceptsNonceAnswer(t *testing.T) {
+	for _, size := range []int{0, 1000, rapidCheckRangeSize + 1, 4 * rapidCheckRangeSize} {
+		content := make([]byte, size)
+		rand.Read(content)
+		d, key := putObject(t, content)
+		nonce := newNonce(t)
+		ranges, err := randomRanges(int64(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hashes := make([]string, len(ranges))
+		for i, r := range ranges {
+			h := sha256.New()
+			h.Write(nonce)
+			h.Write(content[r.Offset : r.Offset+r.Length])
+			hashes[i] = hex.EncodeToString(h.Sum(nil))
+		}
+		if err := verifyRangesAt(context.Background(), d, key, nonce, ranges, hashes); err != nil {
+			t.Fatalf("大小 %d: 正确的应答未通过校验: %v", size, err)
+		}
+		// 使用其他 nonce 计算的应答不能通过
+		if err := verifyRangesAt(context.Background(), d, key, newNonce(t), ranges, hashes); err == nil {
+			t.Fatalf("大小 %d: 其他 nonce 的应答通过了校验", size)
+		}
+	}
+}
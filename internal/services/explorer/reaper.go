@@ -0,0 +1,86 @@
+package explorer
+
+import (
+	"litedrive/internal/models"
+	"litedrive/internal/utils"
+	"log"
+	"time"
+)
+
+// 没有引用的文件实体由后台任务回收: 删除用户文件时只减少引用数,
+// 回收任务在事务中重新确认引用数为 0 后删除文件记录, 提交后再删除物理副本
+
+const (
+	defaultFileReapInterval = 10 * time.Minute
+	// 引用数刚降为 0 的文件保留一段时间, 避免与正在关联该文件的上传竞争
+	defaultFileReapGrace = time.Hour
+	fileReapBatchSize    = 100
+)
+
+// StartFileReaper 启动后台回收任务
+func StartFileReaper() {
+	interval, grace := defaultFileReapInterval, defaultFileReapGrace
+	if config, err := utils.LoadConfig(); err == nil {
+		if config.Storage.FileReapInterval > 0 {
+			interval = time.Duration(config.Storage.FileReapInterval) * time.Second
+		}
+		if config.Storage.FileReapGrace > 0 {
+			grace = time.Duration(config.Storage.FileReapGrace) * time.Second
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ReapFiles(grace)
+		}
+	}()
+	log.Println("文件回收任务已启动, 间隔:", interval)
+}
+
+// ReapFiles 回收引用数为 0 且超过 grace 未更新的文件, 返回回收的文件数
+func ReapFiles(grace time.Duration) int {
+	reaped := 0
+	lastID := uint(0)
+	for {
+		files, err := models.GetUnreferencedFiles(time.Now().Add(-grace), lastID, fileReapBatchSize)
+		if err != nil {
+			log.Printf("查询待回收文件失败: %v", err)
+			break
+		}
+		for _, f := range files {
+			lastID = f.ID
+			file, err := models.ReapFile(f.ID)
+			if err != nil {
+				log.Printf("回收文件 %d 失败: %v", f.ID, err)
+				continue
+			}
+			if file == nil {
+				continue
+			}
+			reaped++
+			// 记录删除后再删除物理副本, 期间上传相同内容而重新引用的对象保留
+			discardUnreferencedReplicas(reapedReplicas(file))
+		}
+		if len(files) < fileReapBatchSize {
+			break
+		}
+	}
+	if reaped > 0 {
+		log.Printf("回收没有引用的文件 %d 个", reaped)
+	}
+	return reaped
+}
+
+// reapedReplicas 已删除的文件实体的全部副本, 没有副本记录的主副本以 File 中的记录为准
+func reapedReplicas(file *models.File) []models.FileReplica {
+	result := []models.FileReplica{{Backend: file.Backend, Path: file.Path}}
+	for _, r := range file.Replicas {
+		if r.Backend == file.Backend && r.Path == file.Path {
+			continue
+		}
+		result = append(result, r)
+	}
+	return result
+}
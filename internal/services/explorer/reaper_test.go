@@ -0,0 +1,100 @@
+package explorer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"litedrive/internal/firesystem"
+	"litedrive/internal/firesystem/local"
+	"litedrive/internal/models"
+	"litedrive/internal/models/modelstest"
+	cmn "litedrive/pkg/common"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// useLocalDriver 注册临时目录中的本地存储驱动作为 storeType 的驱动, 测试结束时恢复原来的驱动
+func useLocalDriver(t *testing.T, storeType cmn.StoreType) *local.Driver {
+	t.Helper()
+	if prev, err := firesystem.GetDriver(storeType); err == nil {
+		t.Cleanup(func() { firesystem.Register(storeType, prev) })
+	}
+	d := &local.Driver{Root: t.TempDir()}
+	firesystem.Register(storeType, d)
+	return d
+}
+
+func writeObject(t *testing.T, d firesystem.Driver, key string, content []byte) {
+	t.Helper()
+	if err := d.Put(context.Background(), key, bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("写入对象 %s 失败: %v", key, err)
+	}
+}
+
+func objectExists(t *testing.T, d firesystem.Driver, key string) bool {
+	t.Helper()
+	_, err := d.Stat(context.Background(), key)
+	if errors.Is(err, firesystem.ErrNotExist) {
+		return false
+	}
+	if err != nil {
+		t.Fatalf("获取对象 %s 信息失败: %v", key, err)
+	}
+	return true
+}
+
+func TestReapFilesRemovesUnreferencedFile(t *testing.T) {
+	modelstest.Open(t)
+	d := useLocalDriver(t, cmn.StoreLocal)
+	file := modelstest.CreateFile(t, "sha-unused", "local", "sh/a-/sha-unused")
+	writeObject(t, d, file.Path, []byte("content"))
+
+	// grace 为负时所有引用数为 0 的文件都到期
+	if n := ReapFiles(-time.Minute); n != 1 {
+		t.Fatalf("回收了 %d 个文件, 期望 1", n)
+	}
+	if objectExists(t, d, file.Path) {
+		t.Fatal("回收后对象未删除")
+	}
+	if _, err := models.GetFileByID(strconv.FormatUint(uint64(file.ID), 10)); err == nil {
+		t.Fatal("回收后文件记录未删除")
+	}
+}
+
+func TestReapFilesKeepsReferencedFile(t *testing.T) {
+	modelstest.Open(t)
+	d := useLocalDriver(t, cmn.StoreLocal)
+	file := modelstest.CreateFile(t, "sha-used", "local", "sh/a-/sha-used")
+	writeObject(t, d, file.Path, []byte("content"))
+	u := &models.UserFile{UserID: 1, FileID: file.ID, FileName: "a.txt"}
+	if err := u.AttachUserFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := ReapFiles(-time.Minute); n != 0 {
+		t.Fatalf("回收了 %d 个文件, 期望 0", n)
+	}
+	if !objectExists(t, d, file.Path) {
+		t.Fatal("仍被引用的文件的对象被删除")
+	}
+}
+
+// 文件记录删除后、删除对象前, 对象已被新上传的文件引用时保留
+func TestReapFilesKeepsObjectReferencedByAnotherFile(t *testing.T) {
+	modelstest.Open(t)
+	d := useLocalDriver(t, cmn.StoreLocal)
+	reaped := modelstest.CreateFile(t, "sha-old", "local", "shared/object")
+	current := modelstest.CreateFile(t, "sha-new", "local", "shared/object")
+	if err := models.DB.Model(current).Update("ref_count", 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	writeObject(t, d, reaped.Path, []byte("content"))
+
+	if n := ReapFiles(-time.Minute); n != 1 {
+		t.Fatalf("回收了 %d 个文件, 期望 1", n)
+	}
+	if !objectExists(t, d, reaped.Path) {
+		t.Fatal("被其他文件引用的对象被删除")
+	}
+}
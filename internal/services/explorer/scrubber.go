@@ -0,0 +1,396 @@
+package explorer
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"litedrive/internal/cache/redis"
+	"litedrive/internal/firesystem"
+	"litedrive/internal/firesystem/blob"
+	"litedrive/internal/models"
+	"litedrive/internal/utils"
+	"litedrive/pkg/serializer"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// 完整性校验: 后台任务按限速重新读取已存储文件的各个副本, 校验大小及 SHA-256,
+// 记录每个文件的校验时间及结果; 损坏或丢失的副本标记后不再用于读取, 存在完好副本时从完好副本修复
+// 后端不可用等无法判断的错误不修改副本状态, 文件在下一轮重新校验
+// 校验的文件数及发现、修复的问题累计在 Redis 的 SCRUB_STATS 中, 多个节点共用
+
+const (
+	scrubStatsKey           = "SCRUB_STATS"
+	defaultScrubInterval    = time.Hour
+	defaultScrubMaxAge      = 7 * 24 * time.Hour
+	defaultScrubRate        = 10 // MB/s
+	defaultScrubBatchSize   = 100
+	defaultScrubReportLimit = 100
+)
+
+// scrubbing 是否有校验任务正在执行, 避免定时任务与手动触发同时执行
+var scrubbing atomic.Bool
+
+// ScrubResult 一轮校验的结果
+type ScrubResult struct {
+	Files    int   `json:"files"`    // 完成校验的文件数
+	Bytes    int64 `json:"bytes"`    // 读取的字节数
+	Corrupt  int   `json:"corrupt"`  // 发现内容不一致的副本数
+	Missing  int   `json:"missing"`  // 发现不存在的副本数
+	Repaired int   `json:"repaired"` // 修复的副本数
+	Skipped  int   `json:"skipped"`  // 后端不可用而跳过的文件数
+}
+
+// scrubOptions 校验任务的配置
+type scrubOptions struct {
+	maxAge      time.Duration // 距上次校验超过该时间的文件重新校验
+	bytesPerSec float64       // 每秒最多读取的字节数
+	batchSize   int
+}
+
+func loadScrubOptions() (time.Duration, scrubOptions) {
+	interval := defaultScrubInterval
+	opts := scrubOptions{
+		maxAge:      defaultScrubMaxAge,
+		bytesPerSec: defaultScrubRate * 1024 * 1024,
+		batchSize:   defaultScrubBatchSize,
+	}
+	config, err := utils.LoadConfig()
+	if err != nil {
+		return interval, opts
+	}
+	if config.Storage.ScrubInterval != 0 {
+		interval = time.Duration(config.Storage.ScrubInterval) * time.Second
+	}
+	if config.Storage.ScrubMaxAge > 0 {
+		opts.maxAge = time.Duration(config.Storage.ScrubMaxAge) * time.Second
+	}
+	if config.Storage.ScrubRate > 0 {
+		opts.bytesPerSec = config.Storage.ScrubRate * 1024 * 1024
+	}
+	if config.Storage.ScrubBatchSize > 0 {
+		opts.batchSize = config.Storage.ScrubBatchSize
+	}
+	return interval, opts
+}
+
+// StartScrubber 启动后台校验任务, scrub_interval 小于 0 时不启动
+func StartScrubber() {
+	interval, opts := loadScrubOptions()
+	if interval < 0 {
+		log.Println("完整性校验任务未启用")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			scrub(context.Background(), opts)
+		}
+	}()
+	log.Println("完整性校验任务已启动, 间隔:", interval)
+}
+
+// ScrubFiles 校验所有到期的文件, 已有校验任务在执行时返回 false
+func ScrubFiles(ctx context.Context) (ScrubResult, bool) {
+	_, opts := loadScrubOptions()
+	return scrub(ctx, opts)
+}
+
+func scrub(ctx context.Context, opts scrubOptions) (ScrubResult, bool) {
+	var result ScrubResult
+	if !scrubbing.CompareAndSwap(false, true) {
+		return result, false
+	}
+	defer scrubbing.Store(false)
+
+	// 本轮开始后校验过的文件不会再次查出, 跳过的文件记录 ID 避免在本轮重复查询
+	started := time.Now()
+	skipped := make(map[uint]bool)
+	for ctx.Err() == nil {
+		files, err := models.GetFilesToVerify(started.Add(-opts.maxAge), opts.batchSize+len(skipped))
+		if err != nil {
+			log.Printf("查询待校验文件失败: %v", err)
+			break
+		}
+		progress := false
+		for i := range files {
+			if ctx.Err() != nil || skipped[files[i].ID] {
+				continue
+			}
+			progress = true
+			if !scrubFile(ctx, &files[i], opts.bytesPerSec, &result) {
+				skipped[files[i].ID] = true
+				result.Skipped++
+			}
+		}
+		if !progress || len(files) < opts.batchSize+len(skipped) {
+			break
+		}
+	}
+
+	recordScrubStats(result)
+	if result.Files > 0 || result.Skipped > 0 {
+		log.Printf("完整性校验: 文件 %d 个, 读取 %d 字节, 损坏副本 %d 个, 丢失副本 %d 个, 修复 %d 个, 跳过 %d 个",
+			result.Files, result.Bytes, result.Corrupt, result.Missing, result.Repaired, result.Skipped)
+	}
+	return result, true
+}
+
+// scrubFile 校验文件的所有副本并修复, 没有任何副本能够确定结果时返回 false
+func scrubFile(ctx context.Context, file *models.File, bytesPerSec float64, result *ScrubResult) bool {
+	replicas, err := blob.Replicas(file)
+	if err != nil {
+		log.Printf("获取文件 %d 的副本失败: %v", file.ID, err)
+		return false
+	}
+
+	// 逐个校验副本, 只记录能确定结果的副本
+	var checked, bad []models.FileReplica
+	var good *models.FileReplica
+	for i := range replicas {
+		r := replicas[i]
+		d, err := firesystem.DriverFor(r.Backend)
+		if err != nil {
+			log.Printf("校验副本 %s:%s 失败: %v", r.Backend, r.Path, err)
+			continue
+		}
+		err = blob.VerifyRate(ctx, d, r.Path, file.Sha, file.Size, bytesPerSec)
+		switch {
+		case err == nil:
+			result.Bytes += file.Size
+			r.Status = models.ReplicaStatusActive
+			good = &replicas[i]
+		case errors.Is(err, firesystem.ErrNotExist):
+			r.Status = models.ReplicaStatusMissing
+			result.Missing++
+		case errors.Is(err, blob.ErrContentMismatch):
+			r.Status = models.ReplicaStatusCorrupt
+			result.Corrupt++
+		default:
+			log.Printf("校验副本 %s:%s 失败: %v", r.Backend, r.Path, err)
+			continue
+		}
+		if r.Status != models.ReplicaStatusActive {
+			log.Printf("文件 %d 的副本 %s:%s 校验失败: %s", file.ID, r.Backend, r.Path, r.Status)
+			bad = append(bad, r)
+		}
+		checked = append(checked, r)
+	}
+	if len(checked) == 0 {
+		return false
+	}
+
+	repaired := 0
+	rewritten := make(map[string]bool)
+	if good != nil {
+		for i := range checked {
+			if checked[i].Status == models.ReplicaStatusActive {
+				continue
+			}
+			// 校验期间副本可能已被迁移, 不再写回
+			if current, err := models.IsReplicaCurrent(file.ID, checked[i].Backend, checked[i].Path); err != nil || !current {
+				continue
+			}
+			rewritten[checked[i].Backend] = true
+			if err := repairReplica(ctx, file, *good, checked[i], bytesPerSec); err != nil {
+				log.Printf("修复文件 %d 的副本 %s:%s 失败: %v", file.ID, checked[i].Backend, checked[i].Path, err)
+				continue
+			}
+			checked[i].Status = models.ReplicaStatusActive
+			repaired++
+		}
+	}
+	result.Repaired += repaired
+
+	status := models.VerifyStatusOK
+	switch {
+	case good == nil && hasReplicaStatus(bad, models.ReplicaStatusCorrupt):
+		status = models.VerifyStatusCorrupt
+	case good == nil:
+		status = models.VerifyStatusMissing
+	case repaired < len(bad):
+		status = models.VerifyStatusDegraded
+	case repaired > 0:
+		status = models.VerifyStatusRepaired
+	}
+
+	stale, err := models.RecordFileVerification(file.ID, status, checked)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 校验期间文件已被回收, 删除修复时写回的对象
+		removeRewritten(file.ID, checked, rewritten)
+		return true
+	}
+	// 修复期间副本已被迁移等操作删除, 删除修复时写回的对象
+	removeRewritten(file.ID, stale, rewritten)
+	if err != nil {
+		log.Printf("记录文件 %d 的校验结果失败: %v", file.ID, err)
+		return false
+	}
+	result.Files++
+	return true
+}
+
+// repairReplica 从完好的副本读取内容覆盖损坏或丢失的副本, 写入后重新校验
+func repairReplica(ctx context.Context, file *models.File, src, dst models.FileReplica, bytesPerSec float64) error {
+	srcDriver, err := firesystem.DriverFor(src.Backend)
+	if err != nil {
+		return err
+	}
+	dstDriver, err := firesystem.DriverFor(dst.Backend)
+	if err != nil {
+		return err
+	}
+
+	reader, err := srcDriver.Get(ctx, src.Path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if err := dstDriver.Put(ctx, dst.Path, firesystem.NewRateLimitedReader(reader, bytesPerSec), file.Size); err != nil {
+		return err
+	}
+	return blob.VerifyRate(ctx, dstDriver, dst.Path, file.Sha, file.Size, bytesPerSec)
+}
+
+// removeRewritten 删除修复时写回、但副本记录已不存在的对象, 对象被其他记录引用时保留
+func removeRewritten(fileID uint, replicas []models.FileReplica, rewritten map[string]bool) {
+	for _, r := range replicas {
+		if !rewritten[r.Backend] {
+			continue
+		}
+		referenced, err := models.ReferencedObjectKeys(r.Backend, []string{r.Path})
+		if err != nil || referenced[r.Path] {
+			continue
+		}
+		d, err := firesystem.DriverFor(r.Backend)
+		if err != nil {
+			continue
+		}
+		if err := d.Delete(context.Background(), r.Path); err != nil {
+			log.Printf("删除文件 %d 修复时写回的对象 %s:%s 失败: %v", fileID, r.Backend, r.Path, err)
+		}
+	}
+}
+
+func hasReplicaStatus(replicas []models.FileReplica, status string) bool {
+	for _, r := range replicas {
+		if r.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// recordScrubStats 累计校验统计, 并记录最近一轮完成的时间
+func recordScrubStats(result ScrubResult) {
+	if redis.RedisCli == nil {
+		return
+	}
+	pipe := redis.RedisCli.Pipeline()
+	pipe.HIncrBy(redis.Ctx, scrubStatsKey, "files", int64(result.Files))
+	pipe.HIncrBy(redis.Ctx, scrubStatsKey, "bytes", result.Bytes)
+	pipe.HIncrBy(redis.Ctx, scrubStatsKey, "corrupt", int64(result.Corrupt))
+	pipe.HIncrBy(redis.Ctx, scrubStatsKey, "missing", int64(result.Missing))
+	pipe.HIncrBy(redis.Ctx, scrubStatsKey, "repaired", int64(result.Repaired))
+	pipe.HSet(redis.Ctx, scrubStatsKey, "last_run", time.Now().Unix())
+	if _, err := pipe.Exec(redis.Ctx); err != nil {
+		log.Printf("更新校验统计失败: %v", err)
+	}
+}
+
+// ScrubService 完整性校验报告及手动触发
+type ScrubService struct {
+	Limit int `form:"limit"` // 返回的问题文件数, 为 0 时默认 100
+}
+
+// ScrubReport 完整性校验报告
+type ScrubReport struct {
+	Running  bool             `json:"running"`           // 是否有校验任务正在执行
+	LastRun  *time.Time       `json:"lastRun,omitempty"` // 最近一轮校验完成的时间
+	Totals   map[string]int64 `json:"totals"`            // 累计校验的文件数、字节数及发现、修复的副本数
+	Statuses map[string]int64 `json:"statuses"`          // 按校验结果统计的文件数, unverified 为从未校验的文件
+	Flagged  []FlaggedFile    `json:"flagged"`           // 发现问题且未修复的文件
+}
+
+// FlaggedFile 校验发现问题的文件
+type FlaggedFile struct {
+	ID           uint                 `json:"id"` // File.ID
+	Sha          string               `json:"sha"`
+	Size         int64                `json:"size"`
+	VerifyStatus string               `json:"verifyStatus"`
+	VerifiedAt   *time.Time           `json:"verifiedAt"`
+	Replicas     []models.FileReplica `json:"replicas"`
+}
+
+// Report 获取完整性校验报告
+func (s *ScrubService) Report(c *gin.Context) serializer.Response {
+	if err := c.ShouldBindQuery(s); err != nil {
+		return serializer.ErrorResponse(err, "参数错误")
+	}
+	limit := s.Limit
+	if limit <= 0 {
+		limit = defaultScrubReportLimit
+	}
+
+	statuses, err := models.CountFilesByVerifyStatus()
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	if n, ok := statuses[""]; ok {
+		delete(statuses, "")
+		statuses["unverified"] = n
+	}
+
+	files, err := models.GetFlaggedFiles(limit)
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	report := ScrubReport{
+		Running:  scrubbing.Load(),
+		Totals:   make(map[string]int64),
+		Statuses: statuses,
+		Flagged:  make([]FlaggedFile, 0, len(files)),
+	}
+	for _, f := range files {
+		report.Flagged = append(report.Flagged, FlaggedFile{
+			ID:           f.ID,
+			Sha:          f.Sha,
+			Size:         f.Size,
+			VerifyStatus: f.VerifyStatus,
+			VerifiedAt:   f.VerifiedAt,
+			Replicas:     f.Replicas,
+		})
+	}
+
+	stats, err := redis.RedisCli.HGetAll(redis.Ctx, scrubStatsKey).Result()
+	if err != nil {
+		return serializer.ErrorResponse(err)
+	}
+	for k, v := range stats {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		if k == "last_run" {
+			t := time.Unix(n, 0)
+			report.LastRun = &t
+			continue
+		}
+		report.Totals[k] = n
+	}
+	return serializer.SuccessResponse(report)
+}
+
+// Run 在后台立即执行一轮校验
+func (s *ScrubService) Run(c *gin.Context) serializer.Response {
+	if scrubbing.Load() {
+		return serializer.SuccessResponse(nil, "校验任务正在执行")
+	}
+	go ScrubFiles(context.Background())
+	return serializer.SuccessResponse(nil, "校验任务已开始")
+}
@@ -0,0 +1,172 @@
+package explorer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"litedrive/internal/firesystem"
+	"litedrive/internal/models"
+	"litedrive/internal/models/modelstest"
+	cmn "litedrive/pkg/common"
+	"testing"
+)
+
+var scrubContent = []byte("litedrive scrub test content")
+
+// createReplicatedFile 创建内容为 scrubContent 的文件实体, 主副本位于 local, 另一个副本位于 ceph
+func createReplicatedFile(t *testing.T) *models.File {
+	t.Helper()
+	sum := sha256.Sum256(scrubContent)
+	sha := hex.EncodeToString(sum[:])
+	file := &models.File{Sha: sha, Size: int64(len(scrubContent)), Path: "local/" + sha, Backend: "local", Status: models.FileStatusStored}
+	if err := models.DB.Create(file).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []models.FileReplica{
+		{FileID: file.ID, Backend: "local", Path: file.Path, Status: models.ReplicaStatusActive},
+		{FileID: file.ID, Backend: "ceph", Path: "ceph/" + sha, Status: models.ReplicaStatusActive},
+	} {
+		if err := models.SaveReplica(models.DB, &r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return file
+}
+
+func readObject(t *testing.T, d firesystem.Driver, key string) []byte {
+	t.Helper()
+	rc, err := d.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("读取对象 %s 失败: %v", key, err)
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// reloadFile 重新读取文件实体的校验结果及副本状态
+func reloadFile(t *testing.T, fileID uint) (models.File, map[string]string) {
+	t.Helper()
+	var file models.File
+	if err := models.DB.Preload("Replicas").First(&file, fileID).Error; err != nil {
+		t.Fatal(err)
+	}
+	statuses := make(map[string]string)
+	for _, r := range file.Replicas {
+		statuses[r.Backend] = r.Status
+	}
+	return file, statuses
+}
+
+func TestScrubFileRepairsCorruptReplica(t *testing.T) {
+	modelstest.Open(t)
+	localDriver := useLocalDriver(t, cmn.StoreLocal)
+	cephDriver := useLocalDriver(t, cmn.StoreCeph)
+	file := createReplicatedFile(t)
+	writeObject(t, localDriver, file.Path, scrubContent)
+	// 大小相同、内容不同的损坏副本
+	corrupt := append([]byte(nil), scrubContent...)
+	corrupt[0] ^= 0xff
+	writeObject(t, cephDriver, "ceph/"+file.Sha, corrupt)
+
+	var result ScrubResult
+	if !scrubFile(context.Background(), file, 0, &result) {
+		t.Fatal("校验被跳过")
+	}
+	if result.Corrupt != 1 || result.Repaired != 1 {
+		t.Fatalf("损坏 %d 个, 修复 %d 个, 期望各 1 个", result.Corrupt, result.Repaired)
+	}
+	if got := readObject(t, cephDriver, "ceph/"+file.Sha); string(got) != string(scrubContent) {
+		t.Fatal("损坏的副本未从完好副本修复")
+	}
+	got, statuses := reloadFile(t, file.ID)
+	if got.VerifyStatus != models.VerifyStatusRepaired {
+		t.Fatalf("校验结果为 %q, 期望 %q", got.VerifyStatus, models.VerifyStatusRepaired)
+	}
+	if statuses["ceph"] != models.ReplicaStatusActive {
+		t.Fatalf("修复后副本状态为 %q, 期望 active", statuses["ceph"])
+	}
+}
+
+func TestScrubFileRepairsMissingPrimary(t *testing.T) {
+	modelstest.Open(t)
+	localDriver := useLocalDriver(t, cmn.StoreLocal)
+	cephDriver := useLocalDriver(t, cmn.StoreCeph)
+	file := createReplicatedFile(t)
+	writeObject(t, cephDriver, "ceph/"+file.Sha, scrubContent)
+
+	var result ScrubResult
+	if !scrubFile(context.Background(), file, 0, &result) {
+		t.Fatal("校验被跳过")
+	}
+	if result.Missing != 1 || result.Repaired != 1 {
+		t.Fatalf("丢失 %d 个, 修复 %d 个, 期望各 1 个", result.Missing, result.Repaired)
+	}
+	if got := readObject(t, localDriver, file.Path); string(got) != string(scrubContent) {
+		t.Fatal("丢失的主副本未从其他副本修复")
+	}
+}
+
+// 没有完好副本时只记录结果, 不修复
+func TestScrubFileWithoutGoodReplica(t *testing.T) {
+	corrupt := append([]byte(nil), scrubContent...)
+	corrupt[0] ^= 0xff
+	cases := []struct {
+		name        string
+		cephContent []byte // 为 nil 时 ceph 副本不存在
+		status      string
+	}{
+		{"所有副本都丢失", nil, models.VerifyStatusMissing},
+		{"副本损坏且其他副本丢失", corrupt, models.VerifyStatusCorrupt},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			modelstest.Open(t)
+			useLocalDriver(t, cmn.StoreLocal)
+			cephDriver := useLocalDriver(t, cmn.StoreCeph)
+			file := createReplicatedFile(t)
+			if tc.cephContent != nil {
+				writeObject(t, cephDriver, "ceph/"+file.Sha, tc.cephContent)
+			}
+
+			var result ScrubResult
+			if !scrubFile(context.Background(), file, 0, &result) {
+				t.Fatal("校验被跳过")
+			}
+			if result.Repaired != 0 {
+				t.Fatalf("修复了 %d 个副本, 期望 0", result.Repaired)
+			}
+			if tc.cephContent != nil {
+				if got := readObject(t, cephDriver, "ceph/"+file.Sha); string(got) != string(tc.cephContent) {
+					t.Fatal("没有完好副本时损坏的副本被改写")
+				}
+			}
+			got, statuses := reloadFile(t, file.ID)
+			if got.VerifyStatus != tc.status {
+				t.Fatalf("校验结果为 %q, 期望 %q", got.VerifyStatus, tc.status)
+			}
+			if statuses["local"] != models.ReplicaStatusMissing {
+				t.Fatalf("主副本状态为 %q, 期望 missing", statuses["local"])
+			}
+		})
+	}
+}
+
+// 后端不可用(测试中没有注册 COS 驱动)时无法确定结果, 不修改校验结果及副本状态
+func TestScrubFileSkipsUnavailableBackend(t *testing.T) {
+	modelstest.Open(t)
+	file := modelstest.CreateFile(t, "sha-unavailable", "cos", "cos/object")
+
+	var result ScrubResult
+	if scrubFile(context.Background(), file, 0, &result) {
+		t.Fatal("后端不可用时应当跳过")
+	}
+	got, _ := reloadFile(t, file.ID)
+	if got.VerifiedAt != nil || got.VerifyStatus != "" {
+		t.Fatalf("跳过的文件记录了校验结果 %q", got.VerifyStatus)
+	}
+}
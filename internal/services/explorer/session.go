@@ -0,0 +1,174 @@
+package explorer
+
+import (
+	"context"
+	"errors"
+	goredis "github.com/redis/go-redis/v9"
+	"litedrive/internal/cache/redis"
+	"litedrive/internal/utils"
+	cmn "litedrive/pkg/common"
+	"litedrive/pkg/serializer"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// 分块上传会话保存在 Redis 中, 每次上传分块都会顺延过期时间
+// 过期时间记录在有序集合 MP_SESSIONS 中, 会话本身在过期后再保留 sessionGCGrace,
+// 让清理任务还能读到远端 multipart upload 的信息并取消它
+
+const (
+	mpSessionsKey           = "MP_SESSIONS"
+	defaultUploadSessionTTL = 24 * time.Hour
+	sessionGCGrace          = time.Hour
+)
+
+var (
+	// errSessionNotFound 会话不存在或已过期
+	errSessionNotFound = errors.New("上传任务不存在或已过期")
+	// errUploadForbidden 上传会话不属于当前用户
+	errUploadForbidden = errors.New("无权操作该上传任务")
+)
+
+// mpKey 分块上传会话
+func mpKey(uploadID string) string {
+	return "MP_" + uploadID
+}
+
+// mpUserKey 用户 + 文件哈希到进行中上传会话的索引, 用于断点续传
+func mpUserKey(userID uint, fileHash string) string {
+	return "MP_USER_" + strconv.FormatUint(uint64(userID), 10) + "_" + fileHash
+}
+
+// uploadSessionTTL 分块上传会话的有效期, 未配置时默认 24 小时
+func uploadSessionTTL() time.Duration {
+	config, err := utils.LoadConfig()
+	if err != nil || config.Storage.UploadSessionTTL <= 0 {
+		return defaultUploadSessionTTL
+	}
+	return time.Duration(config.Storage.UploadSessionTTL) * time.Second
+}
+
+// partRoot 本地暂存分块的根目录, 未配置 TempPartRoot 时使用 Storage.Root 下的 .parts
+func partRoot() (string, error) {
+	config, err := utils.LoadConfig()
+	if err != nil {
+		return "", errors.New("配置文件加载失败")
+	}
+	if config.Storage.TempPartRoot != "" {
+		return config.Storage.TempPartRoot, nil
+	}
+	return filepath.Join(config.Storage.Root, ".parts"), nil
+}
+
+// chunkDir 上传会话的本地分块目录
+func chunkDir(uploadID string) (string, error) {
+	root, err := partRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, uploadID), nil
+}
+
+// touchSession 顺延会话的过期时间, 返回新的过期时间
+func touchSession(ctx context.Context, uploadID string, userID uint, fileHash string) (time.Time, error) {
+	ttl := uploadSessionTTL()
+	expiresAt := time.Now().Add(ttl)
+
+	pipe := redis.RedisCli.TxPipeline()
+	pipe.ZAdd(ctx, mpSessionsKey, goredis.Z{Score: float64(expiresAt.Unix()), Member: uploadID})
+	pipe.Expire(ctx, mpKey(uploadID), ttl+sessionGCGrace)
+	pipe.Expire(ctx, mpUserKey(userID, fileHash), ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return time.Time{}, err
+	}
+	return expiresAt, nil
+}
+
+// loadSession 读取未过期的上传会话及其过期时间
+func loadSession(ctx context.Context, uploadID string) (map[string]string, time.Time, error) {
+	session, err := redis.RedisCli.HGetAll(ctx, mpKey(uploadID)).Result()
+	if err != nil {
+		return nil, time.Time{}, errors.New("Redis 读取失败")
+	}
+	if len(session) == 0 {
+		return nil, time.Time{}, errSessionNotFound
+	}
+
+	scores, err := redis.RedisCli.ZMScore(ctx, mpSessionsKey, uploadID).Result()
+	if err != nil {
+		return nil, time.Time{}, errors.New("Redis 读取失败")
+	}
+	if len(scores) == 0 || scores[0] == 0 {
+		// 历史会话没有登记过期时间, 从现在开始计算
+		userID, _ := strconv.ParseUint(session["user_id"], 10, 64)
+		expiresAt, err := touchSession(ctx, uploadID, uint(userID), session["filehash"])
+		if err != nil {
+			return nil, time.Time{}, errors.New("Redis 写入失败")
+		}
+		return session, expiresAt, nil
+	}
+
+	expiresAt := time.Unix(int64(scores[0]), 0)
+	if time.Now().After(expiresAt) {
+		return nil, time.Time{}, errSessionNotFound
+	}
+	return session, expiresAt, nil
+}
+
+// loadOwnedSession 读取属于指定用户的上传会话, 不属于该用户时返回 errUploadForbidden
+func loadOwnedSession(ctx context.Context, uploadID string, userID uint) (map[string]string, time.Time, error) {
+	session, expiresAt, err := loadSession(ctx, uploadID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if session["user_id"] != strconv.FormatUint(uint64(userID), 10) {
+		return nil, time.Time{}, errUploadForbidden
+	}
+	return session, expiresAt, nil
+}
+
+// sessionErrorResponse 会话不属于当前用户时返回 403
+func sessionErrorResponse(err error) serializer.Response {
+	if errors.Is(err, errUploadForbidden) {
+		return serializer.ForbiddenResponse(err)
+	}
+	return serializer.ErrorResponse(err)
+}
+
+// removeLocalChunk 删除本地暂存的分块
+func removeLocalChunk(uploadID string, chunkIndex int) {
+	dir, err := chunkDir(uploadID)
+	if err != nil {
+		return
+	}
+	if err := os.Remove(filepath.Join(dir, strconv.Itoa(chunkIndex))); err != nil && !os.IsNotExist(err) {
+		log.Printf("删除分块失败: %v", err)
+	}
+}
+
+// discardMultipartUpload 清理分块上传会话: 本地暂存的分块、远端未合并的 multipart upload 及 Redis 记录
+func discardMultipartUpload(uploadID string, session map[string]string) {
+	if dir, err := chunkDir(uploadID); err == nil {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("删除临时目录失败: %v", err)
+		}
+	}
+	if multipartID := session["multipart_id"]; multipartID != "" {
+		if mp, ok := multipartDriver(cmn.ParseStoreType(session["backend"])); ok {
+			if err := mp.AbortMultipart(context.Background(), session["object_key"], multipartID); err != nil {
+				log.Printf("取消分块上传 %s 失败: %v", uploadID, err)
+			}
+		}
+	}
+	// 断点续传索引可能已经指向同一文件的新会话, 只删除指向自己的
+	userID, _ := strconv.ParseUint(session["user_id"], 10, 64)
+	userKey := mpUserKey(uint(userID), session["filehash"])
+	if current, _ := redis.RedisCli.Get(redis.Ctx, userKey).Result(); current == uploadID {
+		redis.RedisCli.Del(redis.Ctx, userKey)
+	}
+	redis.RedisCli.Del(redis.Ctx, mpKey(uploadID))
+	redis.RedisCli.ZRem(redis.Ctx, mpSessionsKey, uploadID)
+}
@@ -0,0 +1,203 @@
+package explorer
+
+import (
+	"context"
+	goredis "github.com/redis/go-redis/v9"
+	"io/fs"
+	"litedrive/internal/cache/redis"
+	"litedrive/internal/models"
+	"litedrive/internal/utils"
+	cmn "litedrive/pkg/common"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// 过期上传会话清理: 取消远端未合并的 multipart upload, 删除 Redis 记录、本地分块目录及未确认的直传对象
+// 分块上传会话记录在 MP_SESSIONS 中, 直传会话记录在 DU_SESSIONS 中
+// 回收的会话数、目录数及字节数累计在 Redis 的 MP_GC_STATS 中, 多个节点共用
+
+const (
+	mpGCStatsKey               = "MP_GC_STATS"
+	defaultUploadSweepInterval = 10 * time.Minute
+)
+
+// SweepResult 一次清理的结果
+type SweepResult struct {
+	Sessions      int   `json:"sessions"`      // 清理的过期会话数
+	RemoteAborted int   `json:"remoteAborted"` // 取消的远端 multipart upload 数
+	Dirs          int   `json:"dirs"`          // 删除的孤立分块目录数
+	Bytes         int64 `json:"bytes"`         // 回收的字节数
+}
+
+// StartUploadSweeper 启动后台清理任务
+func StartUploadSweeper() {
+	interval := defaultUploadSweepInterval
+	if config, err := utils.LoadConfig(); err == nil && config.Storage.UploadSweepInterval > 0 {
+		interval = time.Duration(config.Storage.UploadSweepInterval) * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			SweepUploads()
+		}
+	}()
+	log.Println("上传会话清理任务已启动, 间隔:", interval)
+}
+
+// SweepUploads 清理过期的上传会话及没有会话的分块目录
+func SweepUploads() SweepResult {
+	var result SweepResult
+	sweepExpiredSessions(&result)
+	sweepExpiredDirectUploads(&result)
+	sweepOrphanChunkDirs(&result)
+
+	if result.Sessions > 0 || result.Dirs > 0 {
+		pipe := redis.RedisCli.Pipeline()
+		pipe.HIncrBy(redis.Ctx, mpGCStatsKey, "sessions", int64(result.Sessions))
+		pipe.HIncrBy(redis.Ctx, mpGCStatsKey, "remote_aborted", int64(result.RemoteAborted))
+		pipe.HIncrBy(redis.Ctx, mpGCStatsKey, "dirs", int64(result.Dirs))
+		pipe.HIncrBy(redis.Ctx, mpGCStatsKey, "bytes", result.Bytes)
+		if _, err := pipe.Exec(redis.Ctx); err != nil {
+			log.Printf("更新清理统计失败: %v", err)
+		}
+		log.Printf("清理过期上传: 会话 %d 个, 远端分块上传 %d 个, 分块目录 %d 个, 回收 %d 字节",
+			result.Sessions, result.RemoteAborted, result.Dirs, result.Bytes)
+	}
+	return result
+}
+
+// sweepExpiredSessions 清理 MP_SESSIONS 中已过期的会话
+func sweepExpiredSessions(result *SweepResult) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	uploadIDs, err := redis.RedisCli.ZRangeByScore(redis.Ctx, mpSessionsKey, &goredis.ZRangeBy{Min: "-inf", Max: now}).Result()
+	if err != nil {
+		log.Printf("读取过期上传会话失败: %v", err)
+		return
+	}
+
+	for _, uploadID := range uploadIDs {
+		// 多个节点同时清理时, 只有成功移出集合的节点处理该会话
+		removed, err := redis.RedisCli.ZRem(redis.Ctx, mpSessionsKey, uploadID).Result()
+		if err != nil || removed == 0 {
+			continue
+		}
+		session, err := redis.RedisCli.HGetAll(redis.Ctx, mpKey(uploadID)).Result()
+		if err != nil {
+			log.Printf("读取上传会话 %s 失败: %v", uploadID, err)
+			continue
+		}
+
+		result.Sessions++
+		if len(session) > 0 {
+			for _, chunk := range uploadStatus(uploadID, session, time.Time{}).Chunks {
+				result.Bytes += chunk.Size
+			}
+			if session["multipart_id"] != "" {
+				result.RemoteAborted++
+			}
+		} else if dir, err := chunkDir(uploadID); err == nil {
+			// 会话记录已经过期, 按磁盘占用统计
+			result.Bytes += dirSize(dir)
+		}
+		discardMultipartUpload(uploadID, session)
+	}
+}
+
+// sweepExpiredDirectUploads 清理 DU_SESSIONS 中已过期、客户端未确认的直传:
+// 取消未合并的 multipart upload, 并删除客户端已上传但没有文件实体引用的对象
+func sweepExpiredDirectUploads(result *SweepResult) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	uploadIDs, err := redis.RedisCli.ZRangeByScore(redis.Ctx, duSessionsKey, &goredis.ZRangeBy{Min: "-inf", Max: now}).Result()
+	if err != nil {
+		log.Printf("读取过期直传会话失败: %v", err)
+		return
+	}
+
+	for _, uploadID := range uploadIDs {
+		// 多个节点同时清理时, 只有成功移出集合的节点处理该会话
+		removed, err := redis.RedisCli.ZRem(redis.Ctx, duSessionsKey, uploadID).Result()
+		if err != nil || removed == 0 {
+			continue
+		}
+		session, err := redis.RedisCli.HGetAll(redis.Ctx, directKey(uploadID)).Result()
+		if err != nil {
+			log.Printf("读取直传会话 %s 失败: %v", uploadID, err)
+			continue
+		}
+
+		result.Sessions++
+		if multipartID := session["multipart_id"]; multipartID != "" {
+			if mp, ok := multipartDriver(cmn.ParseStoreType(session["backend"])); ok {
+				if err := mp.AbortMultipart(context.Background(), session["object_key"], multipartID); err != nil {
+					log.Printf("取消分块直传 %s 失败: %v", uploadID, err)
+				} else {
+					result.RemoteAborted++
+				}
+			}
+		}
+		// 确认完成后已被文件实体引用的对象保留
+		if objectKey := session["object_key"]; objectKey != "" {
+			discardUnreferencedReplicas([]models.FileReplica{{Backend: session["backend"], Path: objectKey}})
+		}
+		redis.RedisCli.Del(redis.Ctx, directKey(uploadID))
+	}
+}
+
+// sweepOrphanChunkDirs 删除没有对应会话的分块目录, 例如进程在合并过程中退出留下的目录
+func sweepOrphanChunkDirs(result *SweepResult) {
+	root, err := partRoot()
+	if err != nil {
+		return
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取分块目录失败: %v", err)
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		// 刚创建的目录可能属于正在初始化的会话
+		if err != nil || time.Since(info.ModTime()) < sessionGCGrace {
+			continue
+		}
+		exists, err := redis.RedisCli.Exists(redis.Ctx, mpKey(entry.Name())).Result()
+		if err != nil || exists > 0 {
+			continue
+		}
+
+		dir := filepath.Join(root, entry.Name())
+		size := dirSize(dir)
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("删除孤立分块目录 %s 失败: %v", dir, err)
+			continue
+		}
+		result.Dirs++
+		result.Bytes += size
+	}
+}
+
+// dirSize 统计目录下文件的总大小
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,199 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"litedrive/internal/cache/rabbitmq"
+	"litedrive/internal/firesystem"
+	"litedrive/internal/firesystem/blob"
+	"litedrive/internal/models"
+	"litedrive/internal/queue"
+	"litedrive/pkg/common"
+	"log"
+	"time"
+)
+
+// 异步转移: 写入 COS 等慢速后端的文件先保存在本地并提供下载, 由转移任务写入目标后端后再删除本地副本
+
+const (
+	// 消费者异常退出(例如 RabbitMQ 连接断开)后重新连接的间隔
+	reconnectDelay = 5 * time.Second
+	// 重新投递转移失败的文件时每批查询的文件数
+	requeueBatch = 100
+)
+
+// NewOutboxMessage 生成转移任务, 与文件记录在同一事务中写入发件箱后由后台任务投递
+func NewOutboxMessage(data rabbitmq.TransferData) (models.OutboxMessage, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return models.OutboxMessage{}, err
+	}
+	return models.NewOutboxMessage(queue.TopicTransfer, payload), nil
+}
+
+// Process 将本地暂存的文件写入消息指定的目标后端, 校验目标对象后更新文件记录并删除本地副本
+func Process(msg []byte) error {
+	//解析msg
+	pubData := rabbitmq.TransferData{}
+	err := json.Unmarshal(msg, &pubData)
+	if err != nil {
+		return err
+	}
+
+	// 文件在转移前已被删除时无需处理
+	fileRecord, err := models.GetFileBySha(pubData.FileHash)
+	if err != nil {
+		return err
+	}
+	if fileRecord == nil {
+		log.Printf("文件 %s 已删除, 跳过转移", pubData.FileHash)
+		return nil
+	}
+
+	localDriver, err := firesystem.GetDriver(common.StoreLocal)
+	if err != nil {
+		return err
+	}
+	destDriver, err := firesystem.GetDriver(pubData.DestStoreType)
+	if err != nil {
+		return err
+	}
+
+	// 重复投递的消息, 转移已经完成
+	if fileRecord.Status == models.FileStatusStored && fileRecord.Backend == pubData.DestStoreType.String() {
+		return nil
+	}
+	if err := models.SetFileStatus(pubData.FileHash, models.FileStatusTransferring); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	// 目标即为本地副本时只需更新状态
+	sameObject := pubData.DestStoreType == common.StoreLocal && pubData.DestLocation == pubData.CurLocation
+	if !sameObject {
+		//根据临时存储文件路径，创建文件句柄
+		file, err := localDriver.Get(ctx, pubData.CurLocation)
+		if err != nil {
+			return err
+		}
+		//通过文件句柄将文件内容读出来并且上传到目标存储
+		err = destDriver.Put(ctx, pubData.DestLocation, file, fileRecord.Size)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	// 校验目标对象的大小及哈希, 不一致时删除目标对象后重试
+	if err := blob.Verify(ctx, destDriver, pubData.DestLocation, fileRecord.Sha, fileRecord.Size); err != nil {
+		if !sameObject {
+			destDriver.Delete(ctx, pubData.DestLocation)
+		}
+		return fmt.Errorf("校验目标对象失败: %w", err)
+	}
+
+	//更新文件的存储路径到文件表
+	err = models.UpdateFilePathBySha(pubData.FileHash, pubData.DestStoreType.String(), pubData.DestLocation)
+	if err != nil {
+		return err
+	}
+
+	// 目标后端确认写入后才删除本地副本, 此前下载由本地副本提供
+	if !sameObject {
+		if err := localDriver.Delete(ctx, pubData.CurLocation); err != nil {
+			log.Printf("删除本地副本 %s 失败: %v", pubData.CurLocation, err)
+		}
+	}
+	return nil
+}
+
+// MarkFailed 转移重试耗尽后标记文件, 本地副本保留, 可通过 cmd/transferreplay 重新转移
+func MarkFailed(msg []byte, cause error) {
+	pubData := rabbitmq.TransferData{}
+	if err := json.Unmarshal(msg, &pubData); err != nil {
+		return
+	}
+	log.Printf("文件 %s 转移失败: %v", pubData.FileHash, cause)
+	if err := models.SetFileStatus(pubData.FileHash, models.FileStatusFailed, models.FileStatusStaged, models.FileStatusTransferring); err != nil {
+		log.Printf("更新文件 %s 状态失败: %v", pubData.FileHash, err)
+	}
+}
+
+// MarkReplayed 重新投递后将转移失败的文件标记为转移中
+func MarkReplayed(msg []byte) {
+	data := rabbitmq.TransferData{}
+	if err := json.Unmarshal(msg, &data); err != nil {
+		return
+	}
+	if err := models.SetFileStatus(data.FileHash, models.FileStatusTransferring, models.FileStatusFailed); err != nil {
+		log.Printf("更新文件 %s 状态失败: %v", data.FileHash, err)
+	}
+}
+
+// RequeueFailed 为转移失败的文件重新写入转移任务, 由 API 服务的发件箱投递, 不依赖任务队列的实现
+// 转移失败的文件主副本仍为本地暂存副本, 重新转移到异步转移的目标后端 COS; limit 为 0 时处理全部
+func RequeueFailed(limit int) (int, error) {
+	requeued := 0
+	afterID := uint(0)
+	for limit <= 0 || requeued < limit {
+		files, err := models.FindFiles(models.FileFilter{Status: models.FileStatusFailed}, afterID, requeueBatch)
+		if err != nil {
+			return requeued, err
+		}
+		for _, f := range files {
+			afterID = f.ID
+			if limit > 0 && requeued >= limit {
+				break
+			}
+			if f.Backend != common.StoreLocal.String() {
+				log.Printf("文件 %s 的主副本不在本地, 跳过", f.Sha)
+				continue
+			}
+			destPath, err := firesystem.ObjectKey(common.StoreCOS, f.Sha)
+			if err != nil {
+				return requeued, err
+			}
+			msg, err := NewOutboxMessage(rabbitmq.TransferData{
+				FileHash:      f.Sha,
+				CurLocation:   f.Path,
+				DestLocation:  destPath,
+				DestStoreType: common.StoreCOS,
+			})
+			if err != nil {
+				return requeued, err
+			}
+			ok, err := models.RequeueFailedFile(f.ID, &msg)
+			if err != nil {
+				return requeued, err
+			}
+			if ok {
+				requeued++
+			}
+		}
+		if len(files) < requeueBatch {
+			break
+		}
+	}
+	return requeued, nil
+}
+
+// Run 消费转移任务直到 ctx 取消, 队列不可用时等待后重试, 不会退出进程
+func Run(ctx context.Context, q queue.Queue) {
+	for {
+		err := q.Consume(ctx, queue.TopicTransfer, Process, MarkFailed)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = errors.New("消费者已退出")
+		}
+		log.Printf("转移任务消费中断, %s 后重试: %v", reconnectDelay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
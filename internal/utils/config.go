@@ -14,10 +14,16 @@ type Config struct {
 	Redis    RedisConfig    `mapstructure:"redis"`
 	Ceph     CephConfig     `mapstructure:"ceph"`
 	Cos      CosConfig      `mapstructure:"cos"`
+	RabbitMQ RabbitMQConfig `mapstructure:"rabbitmq"`
+	Queue    QueueConfig    `mapstructure:"queue"`
 }
 
 type ServerConfig struct {
 	Port int `mapstructure:"port"`
+	// 对外访问地址, 用于生成本地存储的下载链接, 为空时使用请求的 Host
+	PublicURL string `mapstructure:"public_url"`
+	// 管理员用户 ID, 可以访问 /api/admin 下的接口
+	AdminUserIDs []uint `mapstructure:"admin_user_ids"`
 }
 
 type DatabaseConfig struct {
@@ -36,6 +42,45 @@ type StorageConfig struct {
 	CephRootDir      string `mapstructure:"ceph_root_dir"`
 	CosRootDir       string `mapstructure:"cos_root_dir"`
 	CurrentStoreType string `mapstructure:"current_store_type"`
+	// 下载链接有效期(秒), 为 0 时默认 3600
+	DownloadURLTTL int `mapstructure:"download_url_ttl"`
+	// 本地存储下载链接的签名密钥, 为空时使用 JWT 密钥
+	URLSignSecret string `mapstructure:"url_sign_secret"`
+	// 直传上传链接有效期(秒), 为 0 时默认 3600
+	UploadURLTTL int `mapstructure:"upload_url_ttl"`
+	// 直传时超过该大小(字节)使用分块上传, 同时作为分块大小, 为 0 时默认 64MB
+	DirectPartSize int64 `mapstructure:"direct_part_size"`
+	// 分块上传会话有效期(秒), 每次上传分块后顺延, 为 0 时默认 24 小时
+	UploadSessionTTL int `mapstructure:"upload_session_ttl"`
+	// 清理过期上传会话的间隔(秒), 为 0 时默认 600
+	UploadSweepInterval int `mapstructure:"upload_sweep_interval"`
+	// 回收没有引用的文件的间隔(秒), 为 0 时默认 600
+	FileReapInterval int `mapstructure:"file_reap_interval"`
+	// 引用数降为 0 后保留的时间(秒), 为 0 时默认 3600
+	FileReapGrace int `mapstructure:"file_reap_grace"`
+	// 秒传范围: global 可以秒传任意用户的文件, user 只能秒传自己的文件, 为空时为 global
+	RapidCheckScope string `mapstructure:"rapid_check_scope"`
+	// 完整性校验的间隔(秒), 为 0 时默认 3600, 小于 0 时不启用
+	ScrubInterval int `mapstructure:"scrub_interval"`
+	// 距上次校验超过该时间(秒)的文件重新校验, 为 0 时默认 7 天
+	ScrubMaxAge int `mapstructure:"scrub_max_age"`
+	// 完整性校验读取的速度上限(MB/s), 为 0 时默认 10
+	ScrubRate float64 `mapstructure:"scrub_rate"`
+	// 每次查询待校验文件的数量, 为 0 时默认 100
+	ScrubBatchSize int `mapstructure:"scrub_batch_size"`
+	// all 模式下写入的后端列表, 为空时写入所有已初始化的后端
+	ReplicaBackends []string `mapstructure:"replica_backends"`
+	// mix 模式下的放置规则, 按顺序匹配第一条, 都不匹配时使用 MixDefault
+	MixRules   []MixRule `mapstructure:"mix_rules"`
+	MixDefault []string  `mapstructure:"mix_default"`
+}
+
+// MixRule 混合存储的放置规则, 条件为空表示不限制
+type MixRule struct {
+	MinSize  int64    `mapstructure:"min_size"`
+	MaxSize  int64    `mapstructure:"max_size"`
+	UserIDs  []uint   `mapstructure:"user_ids"`
+	Backends []string `mapstructure:"backends"`
 }
 
 type CephConfig struct {
@@ -56,6 +101,35 @@ type CosConfig struct {
 	SecretKey string `mapstructure:"secret_key"`
 }
 
+// QueueConfig 后台任务队列配置
+type QueueConfig struct {
+	// 任务队列实现: rabbitmq / memory, 为空时为 rabbitmq
+	Backend string `mapstructure:"backend"`
+	// memory 实现每种任务最多排队的数量, 为 0 时默认 1024
+	BufferSize int `mapstructure:"buffer_size"`
+	// 投递发件箱中任务的间隔(秒), 为 0 时默认 5
+	OutboxInterval int `mapstructure:"outbox_interval"`
+}
+
+// RabbitMQConfig 异步转移使用的 RabbitMQ 配置, 名称为空时使用默认值
+type RabbitMQConfig struct {
+	// 开启后写入 COS 的文件先保存在本地, 由转移服务异步写入 COS
+	AsyncTransferEnable bool   `mapstructure:"async_transfer_enable"`
+	URL                 string `mapstructure:"url"`
+	Exchange            string `mapstructure:"exchange"`
+	Queue               string `mapstructure:"queue"`
+	ErrQueue            string `mapstructure:"err_queue"`
+	RoutingKey          string `mapstructure:"routing_key"`
+	// 转移失败后的最大重试次数, 为 0 时默认 5
+	MaxRetries int `mapstructure:"max_retries"`
+	// 首次重试的延迟(秒), 之后每次翻倍, 为 0 时默认 10
+	RetryDelay int `mapstructure:"retry_delay"`
+	// 转移服务同时处理的消息数, 为 0 时默认 1
+	TransferConcurrency int `mapstructure:"transfer_concurrency"`
+	// 转移服务预取的消息数, 为 0 时等于 TransferConcurrency
+	Prefetch int `mapstructure:"prefetch"`
+}
+
 const defaultConfigPath = "./configs"
 
 func LoadConfig() (*Config, error) {
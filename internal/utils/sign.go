@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// 本地存储没有对象服务, 下载链接由 API 用 HMAC 签名后自行校验
+
+func signSecret() ([]byte, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.Storage.URLSignSecret != "" {
+		return []byte(config.Storage.URLSignSecret), nil
+	}
+	return []byte(config.JWT.Secret), nil
+}
+
+// SignDownload 对用户文件 ID 及过期时间签名
+func SignDownload(userFileID uint, expires int64) (string, error) {
+	secret, err := signSecret()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%d:%d", userFileID, expires)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// VerifyDownload 校验下载链接的签名及有效期
+func VerifyDownload(userFileID uint, expiresStr, signature string) error {
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil {
+		return errors.New("无效的过期时间")
+	}
+	if time.Now().Unix() > expires {
+		return errors.New("下载链接已过期")
+	}
+	expected, err := SignDownload(userFileID, expires)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("下载链接签名无效")
+	}
+	return nil
+}
@@ -36,3 +36,21 @@ func ParseStoreType(t string) StoreType {
 		return StoreLocal
 	}
 }
+
+// String 返回存储类型对应的配置名称,与 ParseStoreType 互逆
+func (t StoreType) String() string {
+	switch t {
+	case StoreLocal:
+		return "local"
+	case StoreCeph:
+		return "ceph"
+	case StoreCOS:
+		return "cos"
+	case StoreMix:
+		return "mix"
+	case StoreAll:
+		return "all"
+	default:
+		return "local"
+	}
+}
@@ -36,3 +36,13 @@ func SuccessResponse(data interface{}, msg ...string) Response {
 		Error: "",
 	}
 }
+
+// ForbiddenResponse 无权访问资源
+func ForbiddenResponse(err error) Response {
+	return Response{
+		Code:  http.StatusForbidden,
+		Data:  nil,
+		Msg:   "无权操作",
+		Error: err.Error(),
+	}
+}